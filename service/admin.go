@@ -0,0 +1,113 @@
+package service
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/go-ocf/kit/log"
+)
+
+const adminSessions = "/api/v1/sessions"
+
+type observationInfo struct {
+	State     observationState `json:"state"`
+	Retries   int              `json:"retries"`
+	LastError string           `json:"lastError,omitempty"`
+}
+
+type resourceInfo struct {
+	DeviceID    string           `json:"di"`
+	Href        string           `json:"href"`
+	InstanceID  int64            `json:"ins"`
+	Observable  bool             `json:"observable"`
+	Subscribers int              `json:"subscribers"`
+	Observation *observationInfo `json:"observation,omitempty"`
+}
+
+type sessionInfo struct {
+	RemoteAddr  string          `json:"remoteAddr"`
+	DeviceID    string          `json:"di"`
+	UserID      string          `json:"uid"`
+	Offline     bool            `json:"offline"`
+	Outstanding int             `json:"outstandingRequests"`
+	LinkQuality linkQualityInfo `json:"linkQuality"`
+	Resources   []resourceInfo  `json:"resources"`
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Errorf("Cannot write admin response: %v", err)
+	}
+}
+
+func (server *Server) adminSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	sessions := server.clientContainer.list()
+	infos := make([]sessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		info := session.info()
+		if di := r.URL.Query().Get("di"); di != "" && info.DeviceID != di {
+			continue
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].RemoteAddr < infos[j].RemoteAddr })
+	writeJSON(w, http.StatusOK, infos)
+}
+
+//adminAuthorized returns true when the request carries the admin token
+func (server *Server) adminAuthorized(r *http.Request) bool {
+	if server.AdminToken == "" {
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(server.AdminToken)) == 1
+}
+
+//requireAdminToken refuses requests of the handler which are not authorized by the admin token
+func (server *Server) requireAdminToken(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !server.adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "admin token is required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//newAdminHandler setup handler of admin server
+func (server *Server) newAdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/vars", server.requireAdminToken(expvar.Handler().ServeHTTP))
+	mux.HandleFunc(adminSessions, server.requireAdminToken(server.adminSessionsHandler))
+	mux.HandleFunc(adminCommands, server.requireAdminToken(server.adminCommandsHandler))
+	mux.HandleFunc(adminBulkCommands, server.requireAdminToken(server.adminBulkCommandsHandler))
+	mux.HandleFunc(adminSubscriptions, server.requireAdminToken(server.adminSubscriptionsHandler))
+	mux.HandleFunc(adminDesiredState, server.requireAdminToken(server.adminDesiredStateHandler))
+	mux.HandleFunc(adminKeepalive, server.requireAdminToken(server.adminKeepaliveHandler))
+	return mux
+}
+
+//serveAdmin starts admin server on the configured address, it is disabled when the address is empty
+func (server *Server) serveAdmin() {
+	if server.AdminAddr == "" {
+		return
+	}
+	go func() {
+		log.Infof("Admin server listens on %v", server.AdminAddr)
+		err := http.ListenAndServe(server.AdminAddr, server.newAdminHandler())
+		if err != nil {
+			log.Errorf("Admin server on %v failed: %v", server.AdminAddr, err)
+		}
+	}()
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAdminSessionsHandler(t *testing.T) {
+	os.Setenv("NETWORK", "tcp")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	s.AdminToken = "secret"
+	handler := s.newAdminHandler()
+	request := func(method, target string) *http.Request {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		return req
+	}
+
+	for _, target := range []string{adminSessions, "/debug/vars"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("unexpected status code %v of %v without token", w.Code, target)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, request(http.MethodGet, adminSessions))
+	if w.Code != http.StatusOK {
+		t.Fatalf("unexpected status code %v", w.Code)
+	}
+	if body := strings.TrimSpace(w.Body.String()); body != "[]" {
+		t.Fatalf("unexpected sessions %v", body)
+	}
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, request(http.MethodPost, adminSessions))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status code %v", w.Code)
+	}
+}
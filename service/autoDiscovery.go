@@ -0,0 +1,203 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/ugorji/go/codec"
+)
+
+const (
+	deviceHref   = "/oic/d"
+	platformHref = "/oic/p"
+)
+
+//discoveredLink link of /oic/res, OIC 1.1 devices nest their links under the device
+type discoveredLink struct {
+	DeviceID      string              `json:"di"`
+	Href          string              `json:"href"`
+	ResourceTypes []string            `json:"rt"`
+	Interfaces    []string            `json:"if"`
+	Anchor        string              `json:"anchor"`
+	Policies      *resources.Policies `json:"p"`
+	Links         []discoveredLink    `json:"links"`
+}
+
+//batchRepresentation item of /oic/res retrieved by batch interface
+type batchRepresentation struct {
+	Href           string      `json:"href"`
+	Representation interface{} `json:"rep"`
+}
+
+//isDiscoverableHref returns false for resources which are served by the gateway or must not leave the device
+func isDiscoverableHref(href string) bool {
+	return href != resourceDiscovery && !strings.HasPrefix(href, "/oic/sec/")
+}
+
+func linkDeviceID(link discoveredLink, parentDeviceID string) string {
+	if link.DeviceID != "" {
+		return link.DeviceID
+	}
+	if strings.HasPrefix(link.Anchor, "ocf://") {
+		return strings.SplitN(strings.TrimPrefix(link.Anchor, "ocf://"), "/", 2)[0]
+	}
+	return parentDeviceID
+}
+
+//parseDiscoveredLinks converts payload of /oic/res to links, deviceID is used for links without device ID
+func parseDiscoveredLinks(payload []byte, deviceID string) ([]resources.Resource, error) {
+	var links []discoveredLink
+	if err := codec.NewDecoderBytes(payload, new(codec.CborHandle)).Decode(&links); err != nil {
+		return nil, err
+	}
+	var rscs []resources.Resource
+	var add func(links []discoveredLink, deviceID string)
+	add = func(links []discoveredLink, deviceID string) {
+		for _, link := range links {
+			di := linkDeviceID(link, deviceID)
+			if len(link.Links) > 0 {
+				add(link.Links, di)
+			}
+			if link.Href == "" || !isDiscoverableHref(link.Href) {
+				continue
+			}
+			rscs = append(rscs, resources.Resource{
+				Href:          link.Href,
+				ResourceTypes: link.ResourceTypes,
+				Interfaces:    link.Interfaces,
+				DeviceId:      di,
+				Anchor:        link.Anchor,
+				Policies:      link.Policies,
+			})
+		}
+	}
+	add(links, deviceID)
+	return rscs, nil
+}
+
+//ensureLink adds link of core resource when the device didn't list it in /oic/res
+func ensureLink(links []resources.Resource, deviceID, href, resourceType string) []resources.Resource {
+	for _, res := range links {
+		if res.DeviceId == deviceID && res.Href == href {
+			return links
+		}
+	}
+	return append(links, resources.Resource{
+		Href:          href,
+		ResourceTypes: []string{resourceType},
+		Interfaces:    []string{"oic.if.baseline", "oic.if.r"},
+		DeviceId:      deviceID,
+		Policies:      &resources.Policies{BitFlags: 1},
+	})
+}
+
+func (session *Session) getDeviceResource(href, query string) (coap.Message, error) {
+	req, err := session.device.NewGetRequest(href)
+	if err != nil {
+		return nil, err
+	}
+	if query != "" {
+		req.SetQueryString(query)
+	}
+	resp, err := session.device.Exchange(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Code() != coap.Content {
+		return nil, fmt.Errorf("unexpected code %v", resp.Code())
+	}
+	return resp, nil
+}
+
+//batchItemHref returns path of the batch item, href of the item can be an ocf:// URI
+func batchItemHref(href string) (string, bool) {
+	if !strings.HasPrefix(href, "ocf://") {
+		return href, true
+	}
+	parts := strings.SplitN(strings.TrimPrefix(href, "ocf://"), "/", 2)
+	if len(parts) != 2 {
+		return "", false
+	}
+	return "/" + parts[1], true
+}
+
+//batchContents retrieves representations of all resources of device by one request, devices without batch interface return nothing
+func (session *Session) batchContents(deviceID string) map[string]*resources.Content {
+	contents := make(map[string]*resources.Content)
+	resp, err := session.getDeviceResource(resourceDiscovery, "if=oic.if.b")
+	if err != nil {
+		log.Debugf("Cannot retrieve batch of device %v: %v", deviceID, err)
+		return contents
+	}
+	var batch []batchRepresentation
+	if err := codec.NewDecoderBytes(resp.Payload(), new(codec.CborHandle)).Decode(&batch); err != nil {
+		log.Errorf("Cannot decode batch of device %v: %v", deviceID, err)
+		return contents
+	}
+	for _, item := range batch {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(item.Representation); err != nil {
+			continue
+		}
+		href, ok := batchItemHref(item.Href)
+		if !ok {
+			log.Debugf("Cannot use batch item %v of device %v without path", item.Href, deviceID)
+			continue
+		}
+		contents[href] = &resources.Content{
+			Data:              data,
+			ContentType:       coapContentFormat2ContentType(int32(coap.AppOcfCbor)),
+			CoapContentFormat: int32(coap.AppOcfCbor),
+		}
+	}
+	return contents
+}
+
+//discoverDeviceResources retrieves links and metadata of the signed-in device and publishes them on behalf of the device
+func (server *Server) discoverDeviceResources(session *Session) {
+	authContext := session.loadAuthorizationContext()
+	deviceID := authContext.GetDeviceId()
+	if len(server.resourceStore.find(deviceID, deviceID, nil, nil)) > 0 {
+		log.Debugf("Auto-discovery of device %v is skipped, it has published resources", deviceID)
+		autoDiscoveryMetric.Add("skipped", 1)
+		return
+	}
+	resp, err := session.getDeviceResource(resourceDiscovery, "")
+	if err != nil {
+		log.Errorf("Cannot discover resources of device %v: %v", deviceID, err)
+		autoDiscoveryMetric.Add("failed", 1)
+		return
+	}
+	links, err := parseDiscoveredLinks(resp.Payload(), deviceID)
+	if err != nil {
+		log.Errorf("Cannot decode resources of device %v: %v", deviceID, err)
+		autoDiscoveryMetric.Add("failed", 1)
+		return
+	}
+	links = ensureLink(links, deviceID, deviceHref, "oic.wk.d")
+	links = ensureLink(links, deviceID, platformHref, "oic.wk.p")
+
+	contents := session.batchContents(deviceID)
+	for _, href := range []string{deviceHref, platformHref} {
+		msg, err := session.getDeviceResource(href, "")
+		if err != nil {
+			log.Errorf("Cannot retrieve %v of device %v: %v", href, deviceID, err)
+			continue
+		}
+		contents[href] = coapMsg2Content(msg)
+	}
+
+	published, spooled := publishLinks(server, session, session.client.RemoteAddr(), authContext, links, server.autoDiscoveryTTL, nil)
+	log.Infof("Auto-discovery of device %v published %v and spooled %v of %v resources", deviceID, len(published), len(spooled), len(links))
+	autoDiscoveryMetric.Add("succeeded", 1)
+	accepted := append(published, spooled...)
+	for _, res := range accepted {
+		if content, ok := contents[res.Href]; ok && res.DeviceId == deviceID {
+			session.notifyResourceChanged(res, content)
+		}
+	}
+	onLinksPublished(server, session, accepted)
+}
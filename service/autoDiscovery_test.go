@@ -0,0 +1,53 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/ugorji/go/codec"
+)
+
+func TestParseDiscoveredLinks(t *testing.T) {
+	for _, payload := range []interface{}{
+		[]map[string]interface{}{
+			{"href": "/oic/res", "rt": []string{"oic.wk.res"}},
+			{"href": "/light", "rt": []string{"core.light"}, "anchor": "ocf://dev"},
+			{"href": "/oic/sec/doxm", "rt": []string{"oic.r.doxm"}},
+		},
+		[]map[string]interface{}{
+			{"di": "dev", "links": []map[string]interface{}{
+				{"href": "/oic/res", "rt": []string{"oic.wk.res"}},
+				{"href": "/light", "rt": []string{"core.light"}},
+			}},
+		},
+	} {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(payload); err != nil {
+			t.Fatalf("%v", err)
+		}
+		links, err := parseDiscoveredLinks(data, "signed")
+		if err != nil {
+			t.Fatalf("cannot parse links: %v", err)
+		}
+		if len(links) != 1 || links[0].Href != "/light" || links[0].DeviceId != "dev" {
+			t.Fatalf("unexpected links %v", links)
+		}
+	}
+}
+
+func TestBatchItemHref(t *testing.T) {
+	tbl := []struct {
+		href string
+		path string
+		ok   bool
+	}{
+		{"/light", "/light", true},
+		{"ocf://dev/light", "/light", true},
+		{"ocf://dev/a/b", "/a/b", true},
+		{"ocf://dev", "", false},
+	}
+	for _, tt := range tbl {
+		if path, ok := batchItemHref(tt.href); path != tt.path || ok != tt.ok {
+			t.Fatalf("unexpected path %v %v of %v", path, ok, tt.href)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+const adminBulkCommands = "/api/v1/commands/bulk"
+
+//bulkCommandQuery selects target resources of devices of the user, values of the same key are ORed, different keys are ANDed
+type bulkCommandQuery struct {
+	UserID        string   `json:"uid"`
+	DeviceIDs     []string `json:"di,omitempty"`
+	ResourceTypes []string `json:"rt,omitempty"`
+	Interfaces    []string `json:"if,omitempty"`
+	Hrefs         []string `json:"href,omitempty"`
+}
+
+func (q bulkCommandQuery) filter() resourceFilter {
+	return resourceFilter{
+		deviceIDs:     q.DeviceIDs,
+		resourceTypes: q.ResourceTypes,
+		interfaces:    q.Interfaces,
+		hrefs:         q.Hrefs,
+	}
+}
+
+//bulkCommand executes the same command on every published resource selected by the query
+type bulkCommand struct {
+	CorrelationID string             `json:"correlationId"`
+	Query         bulkCommandQuery   `json:"query"`
+	Method        commandMethod      `json:"method"`
+	Content       *resources.Content `json:"content,omitempty"`
+	Concurrency   int                `json:"concurrency,omitempty"` // limited by the configured bulk command concurrency
+	DryRun        bool               `json:"dryRun,omitempty"`      // only targets are resolved
+}
+
+type bulkCommandResourceResult struct {
+	Href   string `json:"href"`
+	Status int    `json:"status,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+//bulkCommandResult result of the device, status is the worst status of its resources
+type bulkCommandResult struct {
+	DeviceID  string                      `json:"di"`
+	Status    int                         `json:"status,omitempty"`
+	Error     string                      `json:"error,omitempty"`
+	Resources []bulkCommandResourceResult `json:"resources"`
+}
+
+type bulkCommandResponse struct {
+	CorrelationID string              `json:"correlationId"`
+	DryRun        bool                `json:"dryRun"`
+	Results       []bulkCommandResult `json:"results"`
+}
+
+//resolveBulkCommandTargets finds published resources matching the query of connected devices owned by the user of the query
+func (server *Server) resolveBulkCommandTargets(q bulkCommandQuery) []bulkCommandResult {
+	devices := make(map[string]*wkRd)
+	filter := q.filter()
+	for _, session := range server.clientContainer.findByUserID(q.UserID) {
+		session.findObservedResources(filter, devices)
+	}
+	targets := make([]bulkCommandResult, 0, len(devices))
+	for deviceID, device := range devices {
+		if server.deviceOwners.owner(deviceID) != q.UserID {
+			continue
+		}
+		target := bulkCommandResult{DeviceID: deviceID, Resources: make([]bulkCommandResourceResult, 0, len(device.Links))}
+		for _, res := range device.Links {
+			target.Resources = append(target.Resources, bulkCommandResourceResult{Href: res.Href})
+		}
+		sort.Slice(target.Resources, func(i, j int) bool { return target.Resources[i].Href < target.Resources[j].Href })
+		targets = append(targets, target)
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i].DeviceID < targets[j].DeviceID })
+	return targets
+}
+
+//executeBulkDevice executes the command on resources of the device one by one
+func executeBulkDevice(bulk bulkCommand, result *bulkCommandResult, execute func(cmd resourceCommand) commandResponseEvent) {
+	for i := range result.Resources {
+		res := &result.Resources[i]
+		event := execute(resourceCommand{
+			CorrelationID: fmt.Sprintf("%v/%v%v", bulk.CorrelationID, result.DeviceID, res.Href),
+			UserID:        bulk.Query.UserID,
+			DeviceID:      result.DeviceID,
+			Href:          res.Href,
+			Method:        bulk.Method,
+			Content:       bulk.Content,
+		})
+		res.Status = event.Status
+		res.Error = event.Error
+		if event.Status > result.Status {
+			result.Status = event.Status
+			result.Error = event.Error
+		}
+	}
+}
+
+//executeBulkCommand executes the command on devices in parallel limited by concurrency
+func (server *Server) executeBulkCommand(bulk bulkCommand, execute func(cmd resourceCommand) commandResponseEvent) bulkCommandResponse {
+	response := bulkCommandResponse{
+		CorrelationID: bulk.CorrelationID,
+		DryRun:        bulk.DryRun,
+		Results:       server.resolveBulkCommandTargets(bulk.Query),
+	}
+	if bulk.DryRun {
+		return response
+	}
+
+	concurrency := server.bulkCommandConcurrency
+	if bulk.Concurrency > 0 && bulk.Concurrency < concurrency {
+		concurrency = bulk.Concurrency
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	log.Infof("Bulk command %v %v of %v devices with concurrency %v", bulk.CorrelationID, bulk.Method, len(response.Results), concurrency)
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range response.Results {
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(result *bulkCommandResult) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			executeBulkDevice(bulk, result, execute)
+		}(&response.Results[i])
+	}
+	wg.Wait()
+	return response
+}
+
+func (server *Server) adminBulkCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var bulk bulkCommand
+	if err := json.NewDecoder(r.Body).Decode(&bulk); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := validateCommandMethod(bulk.Method); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if bulk.Query.UserID == "" {
+		http.Error(w, "user id of query is required", http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, http.StatusOK, server.executeBulkCommand(bulk, server.executeCommand))
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/valyala/fasthttp"
+)
+
+func testBulkCommandServer(t *testing.T) *Server {
+	os.Setenv("NETWORK", "tcp")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	s.AdminToken = "secret"
+	return s
+}
+
+//testBulkCommandSession adds signed-in session of the user which published the links of devices owned by owner
+func testBulkCommandSession(s *Server, userID, owner string, links ...resources.Resource) {
+	session := &Session{server: s, observedResources: make(map[string]map[int64]observedResource)}
+	for _, res := range links {
+		if _, ok := session.observedResources[res.DeviceId]; !ok {
+			session.observedResources[res.DeviceId] = make(map[int64]observedResource)
+		}
+		session.observedResources[res.DeviceId][res.InstanceId] = observedResource{res: res}
+		s.deviceOwners.set(res.DeviceId, owner)
+	}
+	s.clientContainer.mutex.Lock()
+	defer s.clientContainer.mutex.Unlock()
+	if _, ok := s.clientContainer.users[userID]; !ok {
+		s.clientContainer.users[userID] = make(map[*Session]bool)
+	}
+	s.clientContainer.users[userID][session] = true
+}
+
+func testBulkCommandLink(deviceID, href string, instanceID int64, resourceTypes ...string) resources.Resource {
+	return resources.Resource{DeviceId: deviceID, Href: href, InstanceId: instanceID, ResourceTypes: resourceTypes}
+}
+
+func testBulkCommandTargets(results []bulkCommandResult) map[string][]string {
+	targets := make(map[string][]string)
+	for _, result := range results {
+		for _, res := range result.Resources {
+			targets[result.DeviceID] = append(targets[result.DeviceID], res.Href)
+		}
+	}
+	return targets
+}
+
+func TestResolveBulkCommandTargets(t *testing.T) {
+	s := testBulkCommandServer(t)
+	testBulkCommandSession(s, "u", "u",
+		testBulkCommandLink("a", "/light/1", 1, "oic.r.light"),
+		testBulkCommandLink("a", "/light/2", 2, "oic.r.light"),
+		testBulkCommandLink("a", "/switch", 3, "oic.r.switch.binary"),
+	)
+	testBulkCommandSession(s, "u", "u", testBulkCommandLink("b", "/light", 1, "oic.r.light"))
+	testBulkCommandSession(s, "u", "v", testBulkCommandLink("c", "/light", 1, "oic.r.light"))
+	testBulkCommandSession(s, "v", "v", testBulkCommandLink("d", "/light", 1, "oic.r.light"))
+
+	tbl := []struct {
+		name    string
+		query   bulkCommandQuery
+		targets map[string][]string
+	}{
+		{"User", bulkCommandQuery{UserID: "u"}, map[string][]string{"a": {"/light/1", "/light/2", "/switch"}, "b": {"/light"}}},
+		{"ResourceType", bulkCommandQuery{UserID: "u", ResourceTypes: []string{"oic.r.light"}}, map[string][]string{"a": {"/light/1", "/light/2"}, "b": {"/light"}}},
+		{"DeviceID", bulkCommandQuery{UserID: "u", DeviceIDs: []string{"b", "d"}}, map[string][]string{"b": {"/light"}}},
+		{"OtherUser", bulkCommandQuery{UserID: "v"}, map[string][]string{"d": {"/light"}}},
+		{"UnknownUser", bulkCommandQuery{UserID: "w"}, map[string][]string{}},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			results := s.resolveBulkCommandTargets(tt.query)
+			targets := testBulkCommandTargets(results)
+			if len(targets) != len(tt.targets) {
+				t.Fatalf("unexpected targets %v", targets)
+			}
+			for deviceID, hrefs := range tt.targets {
+				if strings.Join(targets[deviceID], ",") != strings.Join(hrefs, ",") {
+					t.Fatalf("unexpected targets %v", targets)
+				}
+			}
+		})
+	}
+}
+
+func TestExecuteBulkCommand(t *testing.T) {
+	s := testBulkCommandServer(t)
+	s.bulkCommandConcurrency = 4
+	for _, deviceID := range []string{"a", "b", "c", "d", "e"} {
+		testBulkCommandSession(s, "u", "u",
+			testBulkCommandLink(deviceID, "/light/1", 1, "oic.r.light"),
+			testBulkCommandLink(deviceID, "/light/2", 2, "oic.r.light"),
+		)
+	}
+
+	var mutex sync.Mutex
+	active, maxActive := make(map[string]int), 0
+	execute := func(cmd resourceCommand) commandResponseEvent {
+		mutex.Lock()
+		active[cmd.DeviceID]++
+		if active[cmd.DeviceID] > 1 {
+			t.Errorf("resources of device %v are updated in parallel", cmd.DeviceID)
+		}
+		if len(active) > maxActive {
+			maxActive = len(active)
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		active[cmd.DeviceID]--
+		if active[cmd.DeviceID] == 0 {
+			delete(active, cmd.DeviceID)
+		}
+		mutex.Unlock()
+		if cmd.UserID != "u" || cmd.CorrelationID != "1/"+cmd.DeviceID+cmd.Href {
+			t.Errorf("unexpected command %+v", cmd)
+		}
+		if cmd.DeviceID == "c" && cmd.Href == "/light/2" {
+			return newCommandResponseEvent(cmd, fasthttp.StatusBadGateway, "timeout")
+		}
+		return newCommandResponseEvent(cmd, fasthttp.StatusOK, "")
+	}
+
+	response := s.executeBulkCommand(bulkCommand{CorrelationID: "1", Query: bulkCommandQuery{UserID: "u"}, Method: commandUpdate, Concurrency: 2}, execute)
+	if maxActive != 2 {
+		t.Fatalf("unexpected concurrency %v", maxActive)
+	}
+	if len(response.Results) != 5 {
+		t.Fatalf("unexpected results %+v", response.Results)
+	}
+	for _, result := range response.Results {
+		if len(result.Resources) != 2 {
+			t.Fatalf("unexpected resources of device %+v", result)
+		}
+		status, errMsg := fasthttp.StatusOK, ""
+		if result.DeviceID == "c" {
+			status, errMsg = fasthttp.StatusBadGateway, "timeout"
+		}
+		if result.Status != status || result.Error != errMsg {
+			t.Fatalf("unexpected result of device %+v", result)
+		}
+	}
+
+	response = s.executeBulkCommand(bulkCommand{CorrelationID: "2", Query: bulkCommandQuery{UserID: "u"}, Method: commandUpdate, DryRun: true}, func(cmd resourceCommand) commandResponseEvent {
+		t.Fatalf("dry run executed command %+v", cmd)
+		return commandResponseEvent{}
+	})
+	if !response.DryRun || len(response.Results) != 5 || response.Results[0].Status != 0 {
+		t.Fatalf("unexpected dry run response %+v", response)
+	}
+}
+
+func TestAdminBulkCommandsHandler(t *testing.T) {
+	s := testBulkCommandServer(t)
+	handler := s.newAdminHandler()
+
+	tbl := []struct {
+		name   string
+		body   string
+		token  string
+		status int
+	}{
+		{"WithoutToken", `{"correlationId":"1","query":{"uid":"u"},"method":"update","dryRun":true}`, "", http.StatusUnauthorized},
+		{"InvalidJSON", `{`, "secret", http.StatusBadRequest},
+		{"UnsupportedMethod", `{"query":{"uid":"u"},"method":"observe"}`, "secret", http.StatusBadRequest},
+		{"MissingUser", `{"query":{"rt":["oic.r.light"]},"method":"update"}`, "secret", http.StatusBadRequest},
+		{"DryRun", `{"correlationId":"1","query":{"uid":"u","rt":["oic.r.light"]},"method":"update","dryRun":true}`, "secret", http.StatusOK},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, adminBulkCommands, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status code %v", w.Code)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var response bulkCommandResponse
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			if response.CorrelationID != "1" || !response.DryRun || len(response.Results) != 0 {
+				t.Fatalf("unexpected response %+v", response)
+			}
+		})
+	}
+}
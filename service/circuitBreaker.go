@@ -0,0 +1,68 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-ocf/kit/log"
+)
+
+//circuitBreaker tracks health of an upstream service, it opens after threshold consecutive failures
+//and lets a request through again when openTimeout elapsed
+type circuitBreaker struct {
+	name        string
+	threshold   int
+	openTimeout time.Duration
+
+	failures int
+	openedAt time.Time
+	mutex    sync.Mutex
+}
+
+func newCircuitBreaker(name string, threshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:        name,
+		threshold:   threshold,
+		openTimeout: openTimeout,
+	}
+}
+
+func (c *circuitBreaker) success() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.failures >= c.threshold {
+		log.Infof("Circuit of %v is closed", c.name)
+	}
+	c.failures = 0
+}
+
+func (c *circuitBreaker) failure() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failures++
+	if c.failures >= c.threshold {
+		if c.failures == c.threshold {
+			log.Errorf("Circuit of %v is open", c.name)
+		}
+		c.openedAt = time.Now()
+	}
+}
+
+//isOpen returns true when upstream is considered unavailable
+func (c *circuitBreaker) isOpen() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.threshold <= 0 || c.failures < c.threshold {
+		return false
+	}
+	return time.Since(c.openedAt) < c.openTimeout
+}
+
+//record updates state of circuit by result of a request to upstream
+func (c *circuitBreaker) record(httpCode int, err error) {
+	if err != nil || httpCode >= 500 {
+		c.failure()
+		return
+	}
+	c.success()
+}
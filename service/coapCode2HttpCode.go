@@ -0,0 +1,44 @@
+package service
+
+import (
+	coap "github.com/go-ocf/go-coap"
+	"github.com/valyala/fasthttp"
+)
+
+func coapCode2HttpCode(code coap.COAPCode) int {
+	switch code {
+	case coap.Created:
+		return fasthttp.StatusCreated
+	case coap.Deleted, coap.Changed, coap.Content:
+		return fasthttp.StatusOK
+	case coap.Valid:
+		return fasthttp.StatusNotModified
+	case coap.BadRequest, coap.BadOption:
+		return fasthttp.StatusBadRequest
+	case coap.Unauthorized:
+		return fasthttp.StatusUnauthorized
+	case coap.Forbidden:
+		return fasthttp.StatusForbidden
+	case coap.NotFound:
+		return fasthttp.StatusNotFound
+	case coap.MethodNotAllowed:
+		return fasthttp.StatusMethodNotAllowed
+	case coap.NotAcceptable:
+		return fasthttp.StatusNotAcceptable
+	case coap.PreconditionFailed:
+		return fasthttp.StatusPreconditionFailed
+	case coap.RequestEntityTooLarge:
+		return fasthttp.StatusRequestEntityTooLarge
+	case coap.UnsupportedMediaType:
+		return fasthttp.StatusUnsupportedMediaType
+	case coap.NotImplemented:
+		return fasthttp.StatusNotImplemented
+	case coap.BadGateway:
+		return fasthttp.StatusBadGateway
+	case coap.ServiceUnavailable:
+		return fasthttp.StatusServiceUnavailable
+	case coap.GatewayTimeout:
+		return fasthttp.StatusGatewayTimeout
+	}
+	return fasthttp.StatusInternalServerError
+}
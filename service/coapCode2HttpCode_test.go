@@ -0,0 +1,37 @@
+package service
+
+import (
+	"testing"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/valyala/fasthttp"
+)
+
+func TestCoapCode2HttpCode(t *testing.T) {
+	tbl := []struct {
+		name string
+		in   coap.COAPCode
+		out  int
+	}{
+		{"Created", coap.Created, fasthttp.StatusCreated},
+		{"Deleted", coap.Deleted, fasthttp.StatusOK},
+		{"Changed", coap.Changed, fasthttp.StatusOK},
+		{"Content", coap.Content, fasthttp.StatusOK},
+		{"Valid", coap.Valid, fasthttp.StatusNotModified},
+		{"BadRequest", coap.BadRequest, fasthttp.StatusBadRequest},
+		{"Forbidden", coap.Forbidden, fasthttp.StatusForbidden},
+		{"NotFound", coap.NotFound, fasthttp.StatusNotFound},
+		{"MethodNotAllowed", coap.MethodNotAllowed, fasthttp.StatusMethodNotAllowed},
+		{"UnsupportedMediaType", coap.UnsupportedMediaType, fasthttp.StatusUnsupportedMediaType},
+		{"ServiceUnavailable", coap.ServiceUnavailable, fasthttp.StatusServiceUnavailable},
+		{"InternalServerError", coap.InternalServerError, fasthttp.StatusInternalServerError},
+		{"Unknown", coap.Continue, fasthttp.StatusInternalServerError},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := coapCode2HttpCode(tt.in); code != tt.out {
+				t.Fatalf("unexpected code %v", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/valyala/fasthttp"
+)
+
+const adminCommands = "/api/v1/commands"
+
+//commandMethod operation requested by the cloud on a resource of a device
+type commandMethod string
+
+const (
+	commandUpdate   commandMethod = "update"
+	commandRetrieve commandMethod = "retrieve"
+	commandDelete   commandMethod = "delete"
+	commandCreate   commandMethod = "create"
+)
+
+//resourceCommand request of the cloud which is executed on a resource of a connected device
+type resourceCommand struct {
+	CorrelationID string             `json:"correlationId"`
+	UserID        string             `json:"uid"` // user on behalf of whom the command is executed, it must own the device
+	DeviceID      string             `json:"di"`
+	Href          string             `json:"href"`
+	Method        commandMethod      `json:"method"`
+	Content       *resources.Content `json:"content,omitempty"`
+	TTL           int                `json:"ttl,omitempty"` // seconds the command waits in queue for the device to connect
+	ETag          []byte             `json:"etag,omitempty"`
+	BypassCache   bool               `json:"bypassCache,omitempty"` // retrieve is sent to the device even when the shadow of resource is fresh
+}
+
+//commandResponseEvent is raised with the result of a command, status is a http status code
+type commandResponseEvent struct {
+	CorrelationID string             `json:"correlationId"`
+	UserID        string             `json:"uid,omitempty"`
+	DeviceID      string             `json:"di"`
+	Href          string             `json:"href"`
+	Method        commandMethod      `json:"method"`
+	Status        int                `json:"status"`
+	Content       *resources.Content `json:"content,omitempty"`
+	ETag          []byte             `json:"etag,omitempty"`
+	Error         string             `json:"error,omitempty"`
+}
+
+func contentType2CoapContentFormat(contentType string) (coap.MediaType, error) {
+	for _, mt := range []coap.MediaType{coap.TextPlain, coap.AppLinkFormat, coap.AppXML, coap.AppOctets, coap.AppExi, coap.AppJSON, coap.AppCBOR, coap.AppOcfCbor} {
+		if coapContentFormat2ContentType(int32(mt)) == contentType {
+			return mt, nil
+		}
+	}
+	return 0, fmt.Errorf("unsupported content type %v", contentType)
+}
+
+//newDeviceRequest creates CoAP request of the command, update is mapped to POST and create to POST with the create interface
+func newDeviceRequest(client *deviceClient, cmd resourceCommand) (coap.Message, error) {
+	var data []byte
+	contentFormat := coap.AppOcfCbor
+	if cmd.Content != nil {
+		data = cmd.Content.Data
+		if cmd.Content.ContentType != "" {
+			mt, err := contentType2CoapContentFormat(cmd.Content.ContentType)
+			if err != nil {
+				return nil, err
+			}
+			contentFormat = mt
+		}
+	}
+	switch cmd.Method {
+	case commandRetrieve:
+		req, err := client.NewGetRequest(cmd.Href)
+		if err == nil && len(cmd.ETag) > 0 {
+			req.SetOption(coap.ETag, cmd.ETag)
+		}
+		return req, err
+	case commandUpdate:
+		return client.NewPostRequest(cmd.Href, contentFormat, bytes.NewReader(data))
+	case commandCreate:
+		req, err := client.NewPostRequest(cmd.Href, contentFormat, bytes.NewReader(data))
+		if err == nil {
+			req.SetQueryString("if=oic.if.create")
+		}
+		return req, err
+	case commandDelete:
+		return client.NewDeleteRequest(cmd.Href)
+	}
+	return nil, fmt.Errorf("unsupported method %v", cmd.Method)
+}
+
+func (session *Session) executeCommand(cmd resourceCommand) (coap.Message, error) {
+	req, err := newDeviceRequest(session.device, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return session.device.Exchange(req)
+}
+
+func newCommandResponseEvent(cmd resourceCommand, status int, err string) commandResponseEvent {
+	return commandResponseEvent{
+		CorrelationID: cmd.CorrelationID,
+		UserID:        cmd.UserID,
+		DeviceID:      cmd.DeviceID,
+		Href:          cmd.Href,
+		Method:        cmd.Method,
+		Status:        status,
+		Error:         err,
+	}
+}
+
+func validateCommandMethod(method commandMethod) error {
+	switch method {
+	case commandUpdate, commandRetrieve, commandDelete, commandCreate:
+		return nil
+	}
+	return fmt.Errorf("unsupported method '%v'", method)
+}
+
+func validateCommand(cmd resourceCommand) error {
+	if err := validateCommandMethod(cmd.Method); err != nil {
+		return err
+	}
+	if cmd.UserID == "" || cmd.DeviceID == "" || cmd.Href == "" {
+		return fmt.Errorf("user id, device id and href are required")
+	}
+	return nil
+}
+
+//executeCommand routes the command to the session of the device and raises the response event,
+//command of a device which is not connected is queued when the command queue is enabled
+func (server *Server) executeCommand(cmd resourceCommand) commandResponseEvent {
+	if err := validateCommand(cmd); err != nil {
+		event := newCommandResponseEvent(cmd, fasthttp.StatusBadRequest, err.Error())
+		server.raiseCommandEvent(event)
+		return event
+	}
+	if server.deviceOwners.owner(cmd.DeviceID) != cmd.UserID {
+		event := newCommandResponseEvent(cmd, fasthttp.StatusForbidden, "device is not owned by the user")
+		server.raiseCommandEvent(event)
+		return event
+	}
+	session := server.clientContainer.findByDeviceID(cmd.DeviceID)
+	if server.commandQueue.enabled() {
+		if event, queued := server.queueCommand(cmd, session); queued {
+			return event
+		}
+	}
+	var event commandResponseEvent
+	if session == nil {
+		event = newCommandResponseEvent(cmd, fasthttp.StatusNotFound, "device is not connected")
+	} else {
+		event = server.processSessionCommand(session, cmd)
+	}
+	server.raiseCommandEvent(event)
+	return event
+}
+
+func (server *Server) processSessionCommand(session *Session, cmd resourceCommand) commandResponseEvent {
+	if cmd.Method == commandRetrieve && server.shadowMaxAge > 0 {
+		if event, ok := server.retrieveFromShadow(session, cmd); ok {
+			return event
+		}
+	}
+	if cmd.Method == commandRetrieve && len(cmd.ETag) > 0 && !bytes.Equal(cmd.ETag, session.loadDeviceETag(cmd.DeviceID, cmd.Href)) {
+		// ETag computed by the gateway or an outdated one is not sent to the device
+		cmd.ETag = nil
+	}
+	resp, err := session.executeCommand(cmd)
+	if err != nil {
+		return newCommandResponseEvent(cmd, fasthttp.StatusBadGateway, err.Error())
+	}
+	event := newCommandResponseEvent(cmd, coapCode2HttpCode(resp.Code()), "")
+	if len(resp.Payload()) > 0 {
+		event.Content = coapMsg2Content(resp)
+	}
+	if etag, ok := resp.Option(coap.ETag).([]byte); ok {
+		event.ETag = etag
+	}
+	if resp.Code() >= coap.BadRequest {
+		reportDeviceErrorResponse(resources.Resource{DeviceId: cmd.DeviceID, Href: cmd.Href}, string(cmd.Method), resp.Code())
+	}
+	return event
+}
+
+func (server *Server) adminCommandsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var cmd resourceCommand
+	if err := json.NewDecoder(r.Body).Decode(&cmd); err != nil {
+		writeJSON(w, http.StatusBadRequest, commandResponseEvent{Status: http.StatusBadRequest, Error: err.Error()})
+		return
+	}
+	event := server.executeCommand(cmd)
+	if event.Status == http.StatusNotModified {
+		w.WriteHeader(event.Status)
+		return
+	}
+	writeJSON(w, event.Status, event)
+}
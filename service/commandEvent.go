@@ -0,0 +1,105 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/go-ocf/kit/http"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/go-ocf/resources/uri"
+	"github.com/valyala/fasthttp"
+)
+
+func httpStatus2Status(status int) resources.Status {
+	switch {
+	case status == fasthttp.StatusCreated:
+		return resources.Status_CREATED
+	case status == fasthttp.StatusAccepted:
+		return resources.Status_ACCEPTED
+	case status < fasthttp.StatusBadRequest:
+		return resources.Status_OK
+	case status == fasthttp.StatusBadRequest:
+		return resources.Status_BAD_REQUEST
+	case status == fasthttp.StatusUnauthorized:
+		return resources.Status_UNAUTHORIZED
+	case status == fasthttp.StatusForbidden:
+		return resources.Status_FORBIDDEN
+	case status == fasthttp.StatusNotFound:
+		return resources.Status_NOT_FOUND
+	case status == fasthttp.StatusMethodNotAllowed:
+		return resources.Status_METHOD_NOT_ALLOWED
+	case status == fasthttp.StatusNotImplemented:
+		return resources.Status_NOT_IMPLEMENTED
+	case status == fasthttp.StatusBadGateway, status == fasthttp.StatusServiceUnavailable, status == fasthttp.StatusGatewayTimeout:
+		return resources.Status_UNAVAILABLE
+	}
+	return resources.Status_ERROR
+}
+
+//newConfirmCommandRequest creates request of resource aggregate which confirms the command by its response event
+func newConfirmCommandRequest(authContext *commands.AuthorizationContext, event commandResponseEvent) (path string, request, response protobufRequest) {
+	resourceID := resource2UUID(event.DeviceID, event.Href)
+	status := httpStatus2Status(event.Status)
+	switch event.Method {
+	case commandRetrieve:
+		return uri.ConfirmResourceRetrieve, &commands.ConfirmResourceRetrieveRequest{
+			AuthorizationContext: authContext,
+			ResourceId:           resourceID,
+			CorrelationId:        event.CorrelationID,
+			Status:               status,
+			Content:              event.Content,
+		}, &commands.ConfirmResourceRetrieveResponse{}
+	case commandDelete:
+		return uri.ConfirmResourceDelete, &commands.ConfirmResourceDeleteRequest{
+			AuthorizationContext: authContext,
+			ResourceId:           resourceID,
+			CorrelationId:        event.CorrelationID,
+			Status:               status,
+			Content:              event.Content,
+		}, &commands.ConfirmResourceDeleteResponse{}
+	case commandCreate:
+		return uri.ConfirmResourceCreate, &commands.ConfirmResourceCreateRequest{
+			AuthorizationContext: authContext,
+			ResourceId:           resourceID,
+			CorrelationId:        event.CorrelationID,
+			Status:               status,
+			Content:              event.Content,
+		}, &commands.ConfirmResourceCreateResponse{}
+	}
+	return uri.ConfirmResourceUpdate, &commands.ConfirmResourceUpdateRequest{
+		AuthorizationContext: authContext,
+		ResourceId:           resourceID,
+		CorrelationId:        event.CorrelationID,
+		Status:               status,
+		Content:              event.Content,
+	}, &commands.ConfirmResourceUpdateResponse{}
+}
+
+//raiseCommandEvent confirms the command to resource aggregate by its response event
+func (server *Server) raiseCommandEvent(event commandResponseEvent) {
+	commandsMetric.Add(fmt.Sprintf("%v:%v", event.Method, event.Status), 1)
+	if event.Error != "" {
+		log.Errorf("Command %v %v of ocf://%v%v failed: %v", event.CorrelationID, event.Method, event.DeviceID, event.Href, event.Error)
+	}
+	if event.DeviceID == "" || event.Href == "" {
+		return
+	}
+	session := server.clientContainer.findByDeviceID(event.DeviceID)
+	if session == nil {
+		log.Errorf("Cannot confirm command %v to resource aggregate: device %v is not connected", event.CorrelationID, event.DeviceID)
+		return
+	}
+	// the confirmation is authorized by the session which signed in the device or published it
+	authContext := session.loadAuthorizationContext()
+	path, request, response := newConfirmCommandRequest(&authContext, event)
+	httpRequestCtx := http.AcquireRequestCtx()
+	defer http.ReleaseRequestCtx(httpRequestCtx)
+	httpCode, err := httpRequestCtx.PostProto(server.httpClient, server.ResourceProtocol+"://"+server.ResourceHost+path, request, response)
+	if err == nil && httpCode != fasthttp.StatusOK {
+		err = fmt.Errorf("unexpected status code %v", httpCode)
+	}
+	if err != nil {
+		log.Errorf("Cannot confirm command %v to resource aggregate: %v", event.CorrelationID, err)
+	}
+}
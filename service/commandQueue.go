@@ -0,0 +1,244 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/kit/log"
+	"github.com/valyala/fasthttp"
+)
+
+type queuedCommand struct {
+	Seq      uint64          `json:"seq"`
+	Command  resourceCommand `json:"command"`
+	Deadline time.Time       `json:"deadline"`
+}
+
+//commandQueueRecord is a change of the command queue in its journal
+type commandQueueRecord struct {
+	Queued   *queuedCommand `json:"queued,omitempty"`
+	DeviceID string         `json:"di,omitempty"`
+	Removed  uint64         `json:"removed,omitempty"`
+}
+
+//commandQueue keeps commands of devices which are not connected until they sign in and republish their resources
+type commandQueue struct {
+	journal    *journal
+	size       int           // maximal number of commands per device, zero disables the queue
+	defaultTTL time.Duration // time to live of commands without ttl
+
+	commands map[string][]queuedCommand // [deviceID]
+	flushing map[string]bool
+	seq      uint64
+	mutex    sync.Mutex
+}
+
+func newCommandQueue(file string, size int, defaultTTL time.Duration) (*commandQueue, error) {
+	q := &commandQueue{
+		size:       size,
+		defaultTTL: defaultTTL,
+		commands:   make(map[string][]queuedCommand),
+		flushing:   make(map[string]bool),
+	}
+	var err error
+	q.journal, err = openJournal(file, q.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open command queue '%v': %v", file, err)
+	}
+	return q, nil
+}
+
+func (q *commandQueue) replay(data []byte) error {
+	var r commandQueueRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Queued != nil {
+		deviceID := r.Queued.Command.DeviceID
+		q.commands[deviceID] = append(q.commands[deviceID], *r.Queued)
+		if r.Queued.Seq > q.seq {
+			q.seq = r.Queued.Seq
+		}
+		return nil
+	}
+	q.removeLocked(r.DeviceID, r.Removed)
+	return nil
+}
+
+func (q *commandQueue) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(q.commands))
+	for _, commands := range q.commands {
+		for i := range commands {
+			records = append(records, commandQueueRecord{Queued: &commands[i]})
+		}
+	}
+	return records
+}
+
+func (q *commandQueue) removeLocked(deviceID string, seq uint64) bool {
+	commands := q.commands[deviceID]
+	for i, c := range commands {
+		if c.Seq != seq {
+			continue
+		}
+		if len(commands) == 1 {
+			delete(q.commands, deviceID)
+		} else if i == 0 {
+			q.commands[deviceID] = commands[1:]
+		} else {
+			q.commands[deviceID] = append(commands[:i:i], commands[i+1:]...)
+		}
+		return true
+	}
+	return false
+}
+
+func (q *commandQueue) enabled() bool {
+	return q.size > 0
+}
+
+//enqueue queues the command behind pending commands of the device, command of a connected device
+//without pending commands is not queued and enqueue returns false, so it can be delivered directly
+func (q *commandQueue) enqueue(cmd resourceCommand, now time.Time, connected bool) (bool, error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	commands := q.commands[cmd.DeviceID]
+	if connected && len(commands) == 0 && !q.flushing[cmd.DeviceID] {
+		return false, nil
+	}
+	if len(commands) >= q.size {
+		return false, fmt.Errorf("command queue of device %v is full", cmd.DeviceID)
+	}
+	ttl := q.defaultTTL
+	if cmd.TTL > 0 {
+		ttl = time.Duration(cmd.TTL) * time.Second
+	}
+	q.seq++
+	c := queuedCommand{Seq: q.seq, Command: cmd, Deadline: now.Add(ttl)}
+	q.commands[cmd.DeviceID] = append(commands, c)
+	q.journal.write(commandQueueRecord{Queued: &c}, q.snapshotLocked)
+	return true, nil
+}
+
+//peek returns the head of the queue of a flushing device, the flush ends when the queue is empty
+func (q *commandQueue) peek(deviceID string) (queuedCommand, bool) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	commands := q.commands[deviceID]
+	if len(commands) == 0 {
+		delete(q.flushing, deviceID)
+		return queuedCommand{}, false
+	}
+	return commands[0], true
+}
+
+//remove removes delivered or expired command from the queue
+func (q *commandQueue) remove(c queuedCommand) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	deviceID := c.Command.DeviceID
+	if q.removeLocked(deviceID, c.Seq) {
+		q.journal.write(commandQueueRecord{DeviceID: deviceID, Removed: c.Seq}, q.snapshotLocked)
+	}
+}
+
+//expire removes and returns commands with passed deadline, the head of a flushing device is being delivered and it is kept
+func (q *commandQueue) expire(now time.Time) []queuedCommand {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	var expired []queuedCommand
+	for deviceID, commands := range q.commands {
+		for i, c := range commands {
+			if now.After(c.Deadline) && (i > 0 || !q.flushing[deviceID]) {
+				expired = append(expired, c)
+			}
+		}
+	}
+	for _, c := range expired {
+		q.removeLocked(c.Command.DeviceID, c.Seq)
+		q.journal.write(commandQueueRecord{DeviceID: c.Command.DeviceID, Removed: c.Seq}, q.snapshotLocked)
+	}
+	return expired
+}
+
+func (q *commandQueue) startFlush(deviceID string) bool {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.flushing[deviceID] {
+		return false
+	}
+	q.flushing[deviceID] = true
+	return true
+}
+
+func (q *commandQueue) endFlush(deviceID string) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	delete(q.flushing, deviceID)
+}
+
+//queueCommand queues the command behind pending commands of the device or until the device connects,
+//the response event is raised when it is delivered or expired. It returns false when the command can be delivered directly.
+func (server *Server) queueCommand(cmd resourceCommand, session *Session) (commandResponseEvent, bool) {
+	queued, err := server.commandQueue.enqueue(cmd, time.Now(), session != nil)
+	if err != nil {
+		commandQueueMetric.Add("rejected", 1)
+		event := newCommandResponseEvent(cmd, fasthttp.StatusServiceUnavailable, err.Error())
+		server.raiseCommandEvent(event)
+		return event, true
+	}
+	if !queued {
+		return commandResponseEvent{}, false
+	}
+	commandQueueMetric.Add("queued", 1)
+	if session != nil {
+		go server.flushCommandQueue(cmd.DeviceID)
+	} else {
+		log.Infof("Command %v %v of ocf://%v%v is queued until the device connects", cmd.CorrelationID, cmd.Method, cmd.DeviceID, cmd.Href)
+	}
+	return newCommandResponseEvent(cmd, fasthttp.StatusAccepted, ""), true
+}
+
+//flushCommandQueue delivers queued commands of the device in order, it is called when the device republished its resources.
+//A command stays at the head of the queue until it is delivered, so commands queued meanwhile cannot overtake it.
+func (server *Server) flushCommandQueue(deviceID string) {
+	if !server.commandQueue.startFlush(deviceID) {
+		return
+	}
+	for {
+		c, ok := server.commandQueue.peek(deviceID)
+		if !ok {
+			return
+		}
+		if time.Now().After(c.Deadline) {
+			server.commandQueue.remove(c)
+			server.expireCommand(c)
+			continue
+		}
+		session := server.clientContainer.findByDeviceID(deviceID)
+		if session == nil {
+			server.commandQueue.endFlush(deviceID)
+			return
+		}
+		event := server.processSessionCommand(session, c.Command)
+		server.commandQueue.remove(c)
+		commandQueueMetric.Add("delivered", 1)
+		server.raiseCommandEvent(event)
+	}
+}
+
+func (server *Server) expireCommand(c queuedCommand) {
+	commandQueueMetric.Add("expired", 1)
+	server.raiseCommandEvent(newCommandResponseEvent(c.Command, fasthttp.StatusGatewayTimeout, "command expired before the device connected"))
+}
+
+//expireQueuedCommands periodically raises timeout events of expired commands
+func (server *Server) expireQueuedCommands(interval time.Duration) {
+	for range time.Tick(interval) {
+		for _, c := range server.commandQueue.expire(time.Now()) {
+			server.expireCommand(c)
+		}
+	}
+}
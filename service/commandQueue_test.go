@@ -0,0 +1,70 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCommandQueue(t *testing.T) {
+	dir, remove := testDataDir(t)
+	defer remove()
+	file := filepath.Join(dir, "commands.jsonl")
+
+	q, err := newCommandQueue(file, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("cannot create queue: %v", err)
+	}
+	now := time.Now()
+	if queued, err := q.enqueue(resourceCommand{CorrelationID: "0", DeviceID: "a", Href: "/a", Method: commandRetrieve}, now, true); queued || err != nil {
+		t.Fatalf("command of connected device without pending commands was queued: %v", err)
+	}
+	if queued, err := q.enqueue(resourceCommand{CorrelationID: "1", DeviceID: "a", Href: "/a", Method: commandRetrieve}, now, false); !queued || err != nil {
+		t.Fatalf("cannot queue command: %v", err)
+	}
+	if queued, err := q.enqueue(resourceCommand{CorrelationID: "2", DeviceID: "a", Href: "/a", Method: commandRetrieve, TTL: 1}, now, true); !queued || err != nil {
+		t.Fatalf("command of connected device was not queued behind pending command: %v", err)
+	}
+	if _, err := q.enqueue(resourceCommand{CorrelationID: "3", DeviceID: "a", Href: "/a", Method: commandRetrieve}, now, false); err == nil {
+		t.Fatalf("command was pushed to full queue")
+	}
+
+	q, err = newCommandQueue(file, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("cannot reload queue: %v", err)
+	}
+	if len(q.commands["a"]) != 2 || len(q.commands["b"]) != 0 {
+		t.Fatalf("unexpected queued commands %v after reload", q.commands)
+	}
+	expired := q.expire(now.Add(2 * time.Second))
+	if len(expired) != 1 || expired[0].Command.CorrelationID != "2" {
+		t.Fatalf("unexpected expired commands %v", expired)
+	}
+
+	if !q.startFlush("a") || q.startFlush("a") {
+		t.Fatalf("unexpected flush state")
+	}
+	c, ok := q.peek("a")
+	if !ok || c.Command.CorrelationID != "1" {
+		t.Fatalf("unexpected command %v", c)
+	}
+	if queued, _ := q.enqueue(resourceCommand{CorrelationID: "4", DeviceID: "a", Href: "/a", Method: commandRetrieve}, now, true); !queued {
+		t.Fatalf("command overtook command being delivered")
+	}
+	if expired := q.expire(now.Add(time.Hour)); len(expired) != 1 || expired[0].Command.CorrelationID != "4" {
+		t.Fatalf("unexpected expired commands %v during flush", expired)
+	}
+	q.remove(c)
+
+	q, err = newCommandQueue(file, 2, time.Minute)
+	if err != nil {
+		t.Fatalf("cannot reload queue: %v", err)
+	}
+	if len(q.commands) != 0 {
+		t.Fatalf("queue is not empty: %v", q.commands)
+	}
+	q.startFlush("a")
+	if _, ok := q.peek("a"); ok || q.flushing["a"] {
+		t.Fatalf("flush of empty queue did not end")
+	}
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+func TestAdminCommandsHandler(t *testing.T) {
+	os.Setenv("NETWORK", "tcp")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	s.AdminToken = "secret"
+	s.deviceOwners.set("a", "u")
+	handler := s.newAdminHandler()
+
+	tbl := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"InvalidJSON", `{`, http.StatusBadRequest},
+		{"UnsupportedMethod", `{"correlationId":"1","uid":"u","di":"a","href":"/a","method":"observe"}`, http.StatusBadRequest},
+		{"MissingHref", `{"correlationId":"2","uid":"u","di":"a","method":"retrieve"}`, http.StatusBadRequest},
+		{"MissingUser", `{"correlationId":"3","di":"a","href":"/a","method":"retrieve"}`, http.StatusBadRequest},
+		{"OtherUser", `{"correlationId":"4","uid":"v","di":"a","href":"/a","method":"retrieve"}`, http.StatusForbidden},
+		{"Queued", `{"correlationId":"5","uid":"u","di":"a","href":"/a","method":"retrieve"}`, http.StatusAccepted},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodPost, adminCommands, strings.NewReader(tt.body))
+			req.Header.Set("Authorization", "Bearer secret")
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status code %v", w.Code)
+			}
+			var event commandResponseEvent
+			if err := json.NewDecoder(w.Body).Decode(&event); err != nil {
+				t.Fatalf("cannot decode response event: %v", err)
+			}
+			if event.Status != tt.status || (event.Error == "") != (tt.status == http.StatusAccepted) {
+				t.Fatalf("unexpected response event %+v", event)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, adminCommands, strings.NewReader(tbl[len(tbl)-1].body)))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("command without admin token: unexpected status code %v", w.Code)
+	}
+	w = httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, adminCommands, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	handler.ServeHTTP(w, req)
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status code %v", w.Code)
+	}
+}
+
+func TestHTTPStatus2Status(t *testing.T) {
+	tbl := []struct {
+		status int
+		want   resources.Status
+	}{
+		{http.StatusOK, resources.Status_OK},
+		{http.StatusNotModified, resources.Status_OK},
+		{http.StatusCreated, resources.Status_CREATED},
+		{http.StatusAccepted, resources.Status_ACCEPTED},
+		{http.StatusForbidden, resources.Status_FORBIDDEN},
+		{http.StatusNotFound, resources.Status_NOT_FOUND},
+		{http.StatusGatewayTimeout, resources.Status_UNAVAILABLE},
+		{http.StatusInternalServerError, resources.Status_ERROR},
+	}
+	for _, tt := range tbl {
+		if got := httpStatus2Status(tt.status); got != tt.want {
+			t.Fatalf("status %v: unexpected %v", tt.status, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package service
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+)
+
+//deviceClient sends requests to a device with the device request timeout and tracks the outstanding ones.
+//The device is set as offline and its connection is closed when it misses the timeout maxMisses times in a row.
+type deviceClient struct {
+	*coap.ClientCommander
+	timeout   time.Duration // zero means requests wait for the response without limit
+	maxMisses int           // zero means the connection is never closed because of missed timeouts
+	name      string
+	close     func() error
+	activity  func() // called when the device responds, optional
+
+	outstanding int32
+	misses      int
+	offline     bool
+	mutex       sync.Mutex
+}
+
+func newDeviceClient(client *coap.ClientCommander, timeout time.Duration, maxMisses int) *deviceClient {
+	return &deviceClient{
+		ClientCommander: client,
+		timeout:         timeout,
+		maxMisses:       maxMisses,
+		name:            client.RemoteAddr().String(),
+		close:           client.Close,
+	}
+}
+
+type deviceResponse struct {
+	value interface{}
+	err   error
+}
+
+//do waits for result of the request at most timeout, discard is called with the late result of a timed out request
+func (c *deviceClient) do(operation string, request func() (interface{}, error), discard func(interface{})) (interface{}, error) {
+	atomic.AddInt32(&c.outstanding, 1)
+	result := make(chan deviceResponse, 1)
+	go func() {
+		v, err := request()
+		atomic.AddInt32(&c.outstanding, -1)
+		result <- deviceResponse{value: v, err: err}
+	}()
+
+	var timeout <-chan time.Time
+	if c.timeout > 0 {
+		timer := time.NewTimer(c.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case r := <-result:
+		c.responded()
+		deviceRequestsMetric.Add("completed", 1)
+		return r.value, r.err
+	case <-timeout:
+		go func() {
+			if r := <-result; r.err == nil && discard != nil {
+				discard(r.value)
+			}
+		}()
+		deviceRequestsMetric.Add("timeout", 1)
+		c.missed(operation)
+		return nil, ErrDeviceRequestTimeout
+	}
+}
+
+func (c *deviceClient) responded() {
+	if c.activity != nil {
+		c.activity()
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.misses = 0
+}
+
+func (c *deviceClient) missed(operation string) {
+	c.mutex.Lock()
+	c.misses++
+	misses := c.misses
+	goOffline := c.maxMisses > 0 && misses >= c.maxMisses && !c.offline
+	if goOffline {
+		c.offline = true
+	}
+	c.mutex.Unlock()
+
+	log.Errorf("Device %v didn't respond to %v within %v, missed %v times in a row", c.name, operation, c.timeout, misses)
+	if goOffline {
+		log.Errorf("Device %v is offline, closing connection", c.name)
+		deviceRequestsMetric.Add("offline", 1)
+		if err := c.close(); err != nil {
+			log.Errorf("Cannot close connection of device %v: %v", c.name, err)
+		}
+	}
+}
+
+func (c *deviceClient) health() (outstanding int, offline bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return int(atomic.LoadInt32(&c.outstanding)), c.offline
+}
+
+//Exchange sends the request to the device and waits for the response at most timeout
+func (c *deviceClient) Exchange(req coap.Message) (coap.Message, error) {
+	v, err := c.do("request "+req.PathString(), func() (interface{}, error) {
+		return c.ClientCommander.Exchange(req)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	msg, _ := v.(coap.Message)
+	return msg, nil
+}
+
+//Get retrieves the resource and waits for the response at most timeout
+func (c *deviceClient) Get(path string) (coap.Message, error) {
+	v, err := c.do("get "+path, func() (interface{}, error) {
+		return c.ClientCommander.Get(path)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	msg, _ := v.(coap.Message)
+	return msg, nil
+}
+
+//Observe establishes the observation and waits for it at most timeout, the observation established too late is canceled
+func (c *deviceClient) Observe(path string, handler func(req *coap.Request)) (*coap.Observation, error) {
+	v, err := c.do("observe "+path, func() (interface{}, error) {
+		return c.ClientCommander.Observe(path, handler)
+	}, func(v interface{}) {
+		observation, ok := v.(*coap.Observation)
+		if !ok || observation == nil {
+			return
+		}
+		if err := observation.Cancel(); err != nil {
+			log.Errorf("Cannot cancel late observation of %v: %v", path, err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	observation, _ := v.(*coap.Observation)
+	return observation, nil
+}
@@ -0,0 +1,52 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceClientTimeout(t *testing.T) {
+	closed := 0
+	c := &deviceClient{name: "device", timeout: 10 * time.Millisecond, maxMisses: 2, close: func() error {
+		closed++
+		return nil
+	}}
+
+	respond := func() (interface{}, error) { return "ok", nil }
+	hang := func() (interface{}, error) {
+		time.Sleep(50 * time.Millisecond)
+		return "late", nil
+	}
+
+	if v, err := c.do("respond", respond, nil); err != nil || v != "ok" {
+		t.Fatalf("unexpected result %v, %v", v, err)
+	}
+
+	discarded := make(chan interface{}, 1)
+	if _, err := c.do("hang", hang, func(v interface{}) { discarded <- v }); err != ErrDeviceRequestTimeout {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if outstanding, offline := c.health(); outstanding != 1 || offline {
+		t.Fatalf("unexpected outstanding %v offline %v", outstanding, offline)
+	}
+	select {
+	case v := <-discarded:
+		if v != "late" {
+			t.Fatalf("unexpected discarded result %v", v)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("late result was not discarded")
+	}
+
+	if _, err := c.do("respond", respond, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	c.do("hang", hang, nil)
+	if _, offline := c.health(); offline || closed != 0 {
+		t.Fatalf("device is offline after a miss which followed a response")
+	}
+	c.do("hang", hang, nil)
+	if _, offline := c.health(); !offline || closed != 1 {
+		t.Fatalf("device is not offline after %v misses in a row", c.maxMisses)
+	}
+}
@@ -0,0 +1,114 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+//deviceOwnerRecord is an owner of a device in the journal of device owners
+type deviceOwnerRecord struct {
+	DeviceID string    `json:"di"`
+	UserID   string    `json:"uid"`
+	Claimed  time.Time `json:"claimed,omitempty"` // zero when the device signed in as the user
+}
+
+//deviceOwner user which owns the device, a claimed owner expires unless the claim is refreshed by publish
+type deviceOwner struct {
+	userID  string
+	claimed time.Time
+}
+
+//deviceOwners keeps users which own devices, a device is owned by the user it signed in as,
+//a device which doesn't sign in (eg. behind a bridge) is claimed by the user of the session which published it
+type deviceOwners struct {
+	journal  *journal
+	owners   map[string]deviceOwner // [deviceID]
+	claimTTL time.Duration          // how long a claim lasts after the last publish, zero means forever
+	mutex    sync.Mutex
+}
+
+func newDeviceOwners(file string, claimTTL time.Duration) (*deviceOwners, error) {
+	o := &deviceOwners{
+		owners:   make(map[string]deviceOwner),
+		claimTTL: claimTTL,
+	}
+	var err error
+	o.journal, err = openJournal(file, o.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open device owners '%v': %v", file, err)
+	}
+	return o, nil
+}
+
+func (o *deviceOwners) replay(data []byte) error {
+	var r deviceOwnerRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.DeviceID == "" || r.UserID == "" {
+		return fmt.Errorf("missing device or user")
+	}
+	o.owners[r.DeviceID] = deviceOwner{userID: r.UserID, claimed: r.Claimed}
+	return nil
+}
+
+func (o *deviceOwners) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(o.owners))
+	for deviceID, owner := range o.owners {
+		records = append(records, deviceOwnerRecord{DeviceID: deviceID, UserID: owner.userID, Claimed: owner.claimed})
+	}
+	return records
+}
+
+func (o *deviceOwners) setLocked(deviceID string, owner deviceOwner) {
+	o.owners[deviceID] = owner
+	o.journal.write(deviceOwnerRecord{DeviceID: deviceID, UserID: owner.userID, Claimed: owner.claimed}, o.snapshotLocked)
+}
+
+//ownerLocked returns the owner of the device, an expired claim is not an owner
+func (o *deviceOwners) ownerLocked(deviceID string, now time.Time) (deviceOwner, bool) {
+	owner, ok := o.owners[deviceID]
+	if !ok || owner.claimed.IsZero() || o.claimTTL <= 0 || now.Sub(owner.claimed) < o.claimTTL {
+		return owner, ok
+	}
+	return deviceOwner{}, false
+}
+
+func (o *deviceOwners) owner(deviceID string) string {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	owner, _ := o.ownerLocked(deviceID, time.Now())
+	return owner.userID
+}
+
+//set records the user of the signed-in device
+func (o *deviceOwners) set(deviceID, userID string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if owner, ok := o.owners[deviceID]; ok && owner.userID == userID && owner.claimed.IsZero() {
+		return
+	}
+	o.setLocked(deviceID, deviceOwner{userID: userID})
+}
+
+//claim returns true when the device is owned by the user. The device which has no owner or whose claim expired
+//becomes claimed by the user, the claim of the user is refreshed.
+func (o *deviceOwners) claim(deviceID, userID string) bool {
+	if userID == "" {
+		return false
+	}
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	now := time.Now()
+	owner, ok := o.ownerLocked(deviceID, now)
+	if ok && owner.userID != userID {
+		return false
+	}
+	if ok && (owner.claimed.IsZero() || o.claimTTL <= 0 || now.Sub(owner.claimed) < o.claimTTL/2) {
+		return true
+	}
+	o.setLocked(deviceID, deviceOwner{userID: userID, claimed: now})
+	return true
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeviceOwners(t *testing.T) {
+	testStoreReload(t, func(file string) {
+		o, err := newDeviceOwners(file, time.Hour)
+		if err != nil {
+			t.Fatalf("cannot create device owners: %v", err)
+		}
+		o.set("a", "u")
+		if !o.claim("b", "u") || !o.claim("b", "u") {
+			t.Fatalf("user cannot claim device without owner")
+		}
+		if o.claim("a", "other") || o.claim("b", "other") || o.claim("c", "") {
+			t.Fatalf("device of other user was claimed")
+		}
+		o.set("a", "other")
+	}, func(file string) {
+		o, err := newDeviceOwners(file, time.Hour)
+		if err != nil {
+			t.Fatalf("cannot reload device owners: %v", err)
+		}
+		if o.owner("a") != "other" || o.owner("b") != "u" || o.owner("c") != "" {
+			t.Fatalf("unexpected owners %v after reload", o.owners)
+		}
+	})
+}
+
+func TestDeviceOwnersClaimExpires(t *testing.T) {
+	o, err := newDeviceOwners("", time.Hour)
+	if err != nil {
+		t.Fatalf("cannot create device owners: %v", err)
+	}
+	o.set("a", "u")
+	if !o.claim("b", "u") {
+		t.Fatalf("user cannot claim device without owner")
+	}
+	expired := time.Now().Add(-2 * time.Hour)
+	o.owners["a"] = deviceOwner{userID: "u"}
+	o.owners["b"] = deviceOwner{userID: "u", claimed: expired}
+	if o.owner("a") != "u" || o.owner("b") != "" {
+		t.Fatalf("unexpected owners a: %v, b: %v", o.owner("a"), o.owner("b"))
+	}
+	if !o.claim("b", "other") || o.owner("b") != "other" {
+		t.Fatalf("expired claim was not taken over")
+	}
+	o.set("b", "u")
+	if o.claim("b", "other") || o.owner("b") != "u" {
+		t.Fatalf("sign-in of the device did not override the claim")
+	}
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/ugorji/go/codec"
+	"github.com/valyala/fasthttp"
+)
+
+//status of device is kept by the gateway in a virtual resource of the device
+const (
+	statusHref         = "/oic/cloud/s"
+	statusResourceType = "x.cloud.device.status"
+)
+
+type deviceStatus struct {
+	Online bool `json:"online"`
+}
+
+func virtualResource(deviceID, href, resourceType string) resources.Resource {
+	return resources.Resource{
+		Id:            resource2UUID(deviceID, href),
+		Href:          href,
+		ResourceTypes: []string{resourceType},
+		Interfaces:    []string{"oic.if.baseline"},
+		DeviceId:      deviceID,
+		InstanceId:    resource2InstanceID(deviceID, href),
+		Policies:      &resources.Policies{BitFlags: 1},
+	}
+}
+
+//publishVirtualResource publishes resource of the device which is served by the gateway
+func publishVirtualResource(server *Server, authContext commands.AuthorizationContext, res resources.Resource) error {
+	request := commands.PublishResourceRequest{
+		AuthorizationContext: &authContext,
+		ResourceId:           res.Id,
+		DeviceId:             res.DeviceId,
+		Resource:             &res,
+	}
+
+	httpCode, spooled, err := sendResourceAggregateCommand(server, publishResourceCommand, res.Id, &request)
+	if spooled {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot publish resource %v of device %v: %v", res.Href, res.DeviceId, err)
+	}
+	if httpCode != fasthttp.StatusOK {
+		return fmt.Errorf("cannot publish resource %v of device %v: unexpected status code %v", res.Href, res.DeviceId, httpCode)
+	}
+	return nil
+}
+
+func deviceStatusContent(deviceID string, online bool) (*resources.Content, error) {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(deviceStatus{Online: online}); err != nil {
+		return nil, fmt.Errorf("cannot encode status of device %v: %v", deviceID, err)
+	}
+	return &resources.Content{
+		Data:              data,
+		ContentType:       coapContentFormat2ContentType(int32(coap.AppOcfCbor)),
+		CoapContentFormat: int32(coap.AppOcfCbor),
+	}, nil
+}
+
+//updateDeviceStatus sends status of the device to resource aggregate, the status resource is published before the device goes online
+func updateDeviceStatus(server *Server, authContext commands.AuthorizationContext, online bool) error {
+	res := virtualResource(authContext.GetDeviceId(), statusHref, statusResourceType)
+	if online {
+		if err := publishVirtualResource(server, authContext, res); err != nil {
+			return err
+		}
+	}
+	content, err := deviceStatusContent(res.DeviceId, online)
+	if err != nil {
+		return err
+	}
+	if err := notifyResourceChanged(server, authContext, res, content); err != nil {
+		return err
+	}
+	status := "offline"
+	if online {
+		status = "online"
+		server.onlineDevices.add(authContext)
+	} else {
+		server.onlineDevices.remove(authContext.GetDeviceId())
+	}
+	deviceStatusMetric.Add(status, 1)
+	log.Infof("Device %v is %v", res.DeviceId, status)
+	return nil
+}
+
+//reconcileDeviceStatus sets devices which were online when the gateway stopped as offline. Access tokens of the devices
+//are not persisted, so the offline status is spooled until the device signs in again or it is dropped when spool is disabled.
+func (server *Server) reconcileDeviceStatus() {
+	for _, authContext := range server.onlineDevices.list() {
+		if err := spoolOfflineStatus(server, authContext); err != nil {
+			log.Errorf("Cannot reconcile status of device %v, offline status is dropped: %v", authContext.GetDeviceId(), err)
+		}
+		server.onlineDevices.remove(authContext.GetDeviceId())
+	}
+}
+
+//spoolOfflineStatus spools offline status of the device, it is sent with access token of the device when it signs in
+func spoolOfflineStatus(server *Server, authContext commands.AuthorizationContext) error {
+	if !server.resourceSpool.enabled() {
+		return fmt.Errorf("spool of resource aggregate is disabled")
+	}
+	res := virtualResource(authContext.GetDeviceId(), statusHref, statusResourceType)
+	content, err := deviceStatusContent(res.DeviceId, false)
+	if err != nil {
+		return err
+	}
+	request := commands.NotifyResourceChangedRequest{
+		AuthorizationContext: &authContext,
+		ResourceId:           res.Id,
+		Content:              content,
+	}
+	data, err := request.Marshal()
+	if err != nil {
+		return fmt.Errorf("cannot spool status of device %v: %v", res.DeviceId, err)
+	}
+	server.resourceSpool.add(notifyResourceChangedCommand, res.Id, data, "")
+	log.Infof("Offline status of device %v is spooled until it signs in", res.DeviceId)
+	return nil
+}
+
+//onlineDevice is a device which is online, the access token is not persisted
+type onlineDevice struct {
+	DeviceID string `json:"di"`
+	UserID   string `json:"uid"`
+}
+
+//onlineDevicesRecord is a device which went online or offline in the journal of online devices
+type onlineDevicesRecord struct {
+	Online  *onlineDevice `json:"online,omitempty"`
+	Offline string        `json:"offline,omitempty"`
+}
+
+//onlineDevicesStore persists devices which are online, so they can be set as offline after a crash of the gateway
+type onlineDevicesStore struct {
+	journal *journal
+	devices map[string]onlineDevice
+	mutex   sync.Mutex
+}
+
+func newOnlineDevicesStore(file string) (*onlineDevicesStore, error) {
+	s := &onlineDevicesStore{
+		devices: make(map[string]onlineDevice),
+	}
+	var err error
+	s.journal, err = openJournal(file, s.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open online devices '%v': %v", file, err)
+	}
+	return s, nil
+}
+
+func (s *onlineDevicesStore) replay(data []byte) error {
+	var r onlineDevicesRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Online != nil {
+		s.devices[r.Online.DeviceID] = *r.Online
+	} else {
+		delete(s.devices, r.Offline)
+	}
+	return nil
+}
+
+func (s *onlineDevicesStore) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(s.devices))
+	for _, device := range s.devices {
+		device := device
+		records = append(records, onlineDevicesRecord{Online: &device})
+	}
+	return records
+}
+
+func (s *onlineDevicesStore) add(authContext commands.AuthorizationContext) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	device := onlineDevice{DeviceID: authContext.GetDeviceId(), UserID: authContext.GetUserId()}
+	s.devices[device.DeviceID] = device
+	s.journal.write(onlineDevicesRecord{Online: &device}, s.snapshotLocked)
+}
+
+func (s *onlineDevicesStore) remove(deviceID string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.devices[deviceID]; !ok {
+		return
+	}
+	delete(s.devices, deviceID)
+	s.journal.write(onlineDevicesRecord{Offline: deviceID}, s.snapshotLocked)
+}
+
+//list returns authorization contexts of online devices without access tokens
+func (s *onlineDevicesStore) list() []commands.AuthorizationContext {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	devices := make([]commands.AuthorizationContext, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, commands.AuthorizationContext{DeviceId: device.DeviceID, UserId: device.UserID})
+	}
+	return devices
+}
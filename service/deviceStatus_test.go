@@ -0,0 +1,93 @@
+package service
+
+import (
+	"os"
+	"testing"
+
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/ugorji/go/codec"
+)
+
+func TestOnlineDevicesStorePersistence(t *testing.T) {
+	testStoreReload(t, func(file string) {
+		s, err := newOnlineDevicesStore(file)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+		s.add(commands.AuthorizationContext{DeviceId: "a", UserId: "u", AccessToken: "t"})
+		s.add(commands.AuthorizationContext{DeviceId: "b", UserId: "u", AccessToken: "t"})
+		s.remove("b")
+	}, func(file string) {
+		s, err := newOnlineDevicesStore(file)
+		if err != nil {
+			t.Fatalf("cannot reload store: %v", err)
+		}
+		devices := s.list()
+		if len(devices) != 1 || devices[0].DeviceId != "a" || devices[0].UserId != "u" || devices[0].AccessToken != "" {
+			t.Fatalf("unexpected online devices %v after reload", devices)
+		}
+	})
+}
+
+func TestIsSignOut(t *testing.T) {
+	encode := func(v interface{}) []byte {
+		var data []byte
+		if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(v); err != nil {
+			t.Fatalf("cannot encode %v: %v", v, err)
+		}
+		return data
+	}
+	tbl := []struct {
+		name    string
+		payload []byte
+		out     bool
+	}{
+		{"SignIn", encode(map[string]interface{}{"di": "a", "login": true}), false},
+		{"WithoutLogin", encode(map[string]interface{}{"di": "a"}), false},
+		{"SignOut", encode(map[string]interface{}{"di": "a", "login": false}), true},
+		{"Invalid", []byte{0xff}, false},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			if out := isSignOut(tt.payload); out != tt.out {
+				t.Fatalf("unexpected sign-out %v", out)
+			}
+		})
+	}
+}
+
+func TestReconcileDeviceStatus(t *testing.T) {
+	tbl := []struct {
+		name    string
+		entries int
+		spooled int
+	}{
+		{"Spooled", 10, 1},
+		{"Dropped", 0, 0},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("NETWORK", "tcp")
+			s, err := NewServer()
+			if err != nil {
+				t.Fatalf("cannot create server: %v", err)
+			}
+			s.resourceSpool, err = newResourceAggregateSpool("", tt.entries, 0)
+			if err != nil {
+				t.Fatalf("cannot create spool: %v", err)
+			}
+			s.onlineDevices.add(commands.AuthorizationContext{DeviceId: "a", UserId: "u"})
+			s.reconcileDeviceStatus()
+			if devices := s.onlineDevices.list(); len(devices) != 0 {
+				t.Fatalf("unexpected online devices %v after reconcile", devices)
+			}
+			spooled := s.resourceSpool.list()
+			if len(spooled) != tt.spooled {
+				t.Fatalf("unexpected spooled commands %v", spooled)
+			}
+			if len(spooled) > 0 && (spooled[0].Command != notifyResourceChangedCommand || spooled[0].ResourceID != resource2UUID("a", statusHref)) {
+				t.Fatalf("unexpected spooled command %+v", spooled[0])
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/ugorji/go/codec"
+	"github.com/valyala/fasthttp"
+)
+
+const adminDesiredState = "/api/v1/desired"
+
+type twinStatus string
+
+const (
+	twinPending   twinStatus = "pending"
+	twinConverged twinStatus = "converged"
+	twinConflict  twinStatus = "conflict" // device kept reporting a different value after maxAttempts updates
+	twinFailed    twinStatus = "failed"
+)
+
+//desiredState representation of a resource stored by the cloud, the device is updated until it reports it
+type desiredState struct {
+	DeviceID  string             `json:"di"`
+	Href      string             `json:"href"`
+	Content   *resources.Content `json:"content"`
+	Status    twinStatus         `json:"status"`
+	Attempts  int                `json:"attempts"`
+	LastError string             `json:"lastError,omitempty"`
+	Updated   time.Time          `json:"updated"`
+}
+
+//deviceTwinRecord is a stored or removed desired state in the journal of the device twin
+type deviceTwinRecord struct {
+	State   *desiredState `json:"state"`
+	Removed bool          `json:"removed,omitempty"`
+}
+
+//deviceTwin keeps desired states of resources and decides when the device is updated
+type deviceTwin struct {
+	journal     *journal
+	maxAttempts int
+
+	states   map[string]map[string]*desiredState // [deviceID][href]
+	updating map[string]bool                     // [deviceID+href] update is sent to the device
+	mutex    sync.Mutex
+}
+
+func newDeviceTwin(file string, maxAttempts int) (*deviceTwin, error) {
+	t := &deviceTwin{
+		maxAttempts: maxAttempts,
+		states:      make(map[string]map[string]*desiredState),
+		updating:    make(map[string]bool),
+	}
+	var err error
+	t.journal, err = openJournal(file, t.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open desired states '%v': %v", file, err)
+	}
+	return t, nil
+}
+
+func (t *deviceTwin) replay(data []byte) error {
+	var r deviceTwinRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.State == nil {
+		return fmt.Errorf("missing desired state")
+	}
+	if r.Removed {
+		t.removeLocked(r.State.DeviceID, r.State.Href)
+	} else {
+		t.storeLocked(r.State)
+	}
+	return nil
+}
+
+func (t *deviceTwin) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(t.states))
+	for _, deviceStates := range t.states {
+		for _, state := range deviceStates {
+			records = append(records, deviceTwinRecord{State: state})
+		}
+	}
+	return records
+}
+
+func (t *deviceTwin) storeLocked(state *desiredState) {
+	if _, ok := t.states[state.DeviceID]; !ok {
+		t.states[state.DeviceID] = make(map[string]*desiredState)
+	}
+	t.states[state.DeviceID][state.Href] = state
+}
+
+func (t *deviceTwin) removeLocked(deviceID, href string) bool {
+	if _, ok := t.states[deviceID][href]; !ok {
+		return false
+	}
+	delete(t.states[deviceID], href)
+	if len(t.states[deviceID]) == 0 {
+		delete(t.states, deviceID)
+	}
+	return true
+}
+
+func isCBORContent(content *resources.Content) bool {
+	return content.ContentType == "application/cbor" || content.ContentType == "application/vnd.ocf+cbor"
+}
+
+//desiredContentMatches returns true when the reported content contains all properties of the desired content
+func desiredContentMatches(desired, reported *resources.Content) bool {
+	if reported == nil {
+		return false
+	}
+	if !isCBORContent(desired) || !isCBORContent(reported) {
+		return bytes.Equal(desired.Data, reported.Data)
+	}
+	var d, r map[string]interface{}
+	if err := codec.NewDecoderBytes(desired.Data, new(codec.CborHandle)).Decode(&d); err != nil {
+		return false
+	}
+	if err := codec.NewDecoderBytes(reported.Data, new(codec.CborHandle)).Decode(&r); err != nil {
+		return false
+	}
+	for k, v := range d {
+		if !reflect.DeepEqual(v, r[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t *deviceTwin) set(state desiredState) desiredState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state.Status = twinPending
+	state.Attempts = 0
+	state.LastError = ""
+	state.Updated = time.Now()
+	t.storeLocked(&state)
+	t.journal.write(deviceTwinRecord{State: &state}, t.snapshotLocked)
+	return state
+}
+
+func (t *deviceTwin) remove(deviceID, href string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if !t.removeLocked(deviceID, href) {
+		return false
+	}
+	t.journal.write(deviceTwinRecord{State: &desiredState{DeviceID: deviceID, Href: href}, Removed: true}, t.snapshotLocked)
+	return true
+}
+
+func (t *deviceTwin) list(deviceID string) []desiredState {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	states := make([]desiredState, 0, 16)
+	for di, deviceStates := range t.states {
+		if deviceID != "" && di != deviceID {
+			continue
+		}
+		for _, state := range deviceStates {
+			states = append(states, *state)
+		}
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].DeviceID == states[j].DeviceID {
+			return states[i].Href < states[j].Href
+		}
+		return states[i].DeviceID < states[j].DeviceID
+	})
+	return states
+}
+
+func (t *deviceTwin) hrefs(deviceID string) []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	hrefs := make([]string, 0, len(t.states[deviceID]))
+	for href := range t.states[deviceID] {
+		hrefs = append(hrefs, href)
+	}
+	sort.Strings(hrefs)
+	return hrefs
+}
+
+func (t *deviceTwin) setStatusLocked(state *desiredState, status twinStatus, lastError string) {
+	if state.Status != status {
+		log.Infof("Desired state of ocf://%v%v is %v", state.DeviceID, state.Href, status)
+		twinMetric.Add(string(status), 1)
+	}
+	state.Status = status
+	state.LastError = lastError
+	state.Updated = time.Now()
+	t.journal.write(deviceTwinRecord{State: state}, t.snapshotLocked)
+}
+
+//reported compares the reported content with the desired one and returns desired content when the device must be updated
+func (t *deviceTwin) reported(deviceID, href string, reported *resources.Content) (*resources.Content, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	state, ok := t.states[deviceID][href]
+	if !ok {
+		return nil, false
+	}
+	if desiredContentMatches(state.Content, reported) {
+		state.Attempts = 0
+		if state.Status != twinConverged {
+			t.setStatusLocked(state, twinConverged, "")
+		}
+		return nil, false
+	}
+	if state.Status == twinConflict || t.updating[deviceID+href] {
+		return nil, false
+	}
+	if t.maxAttempts > 0 && state.Attempts >= t.maxAttempts {
+		log.Errorf("Device keeps reporting ocf://%v%v different from desired state after %v updates", deviceID, href, state.Attempts)
+		t.setStatusLocked(state, twinConflict, "device reports different value")
+		return nil, false
+	}
+	state.Attempts++
+	t.updating[deviceID+href] = true
+	if state.Status != twinPending {
+		t.setStatusLocked(state, twinPending, state.LastError)
+	}
+	return state.Content, true
+}
+
+//updated records result of the update sent to the device
+func (t *deviceTwin) updated(deviceID, href string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	delete(t.updating, deviceID+href)
+	state, ok := t.states[deviceID][href]
+	if !ok || err == nil {
+		return
+	}
+	t.setStatusLocked(state, twinFailed, err.Error())
+}
+
+//reconcileDesiredState updates the resource of device when the reported content differs from desired state
+func (server *Server) reconcileDesiredState(session *Session, deviceID, href string, reported *resources.Content) {
+	if reported == nil {
+		// the shadow is empty until the first notification or response of the device, the notification reconciles it
+		return
+	}
+	desired, update := server.deviceTwin.reported(deviceID, href, reported)
+	if !update {
+		return
+	}
+	event := server.processSessionCommand(session, resourceCommand{
+		CorrelationID: "desired/" + deviceID + href,
+		DeviceID:      deviceID,
+		Href:          href,
+		Method:        commandUpdate,
+		Content:       desired,
+	})
+	var err error
+	if event.Error != "" {
+		err = fmt.Errorf("%v", event.Error)
+	} else if event.Status >= fasthttp.StatusMultipleChoices {
+		err = fmt.Errorf("device responded with status %v", event.Status)
+	}
+	server.deviceTwin.updated(deviceID, href, err)
+}
+
+//reconcileDevice compares desired states of the device with its shadow, it is called when the device republished its resources,
+//resources without reported content are reconciled by their first notification
+func (server *Server) reconcileDevice(session *Session, deviceID string) {
+	for _, href := range server.deviceTwin.hrefs(deviceID) {
+		reported, _, _ := session.loadShadow(deviceID, href, time.Duration(math.MaxInt64))
+		server.reconcileDesiredState(session, deviceID, href, reported)
+	}
+}
+
+//adminDesiredStateHandler manages desired states of devices owned by the user given by query parameter uid
+func (server *Server) adminDesiredStateHandler(w http.ResponseWriter, r *http.Request) {
+	userID := r.URL.Query().Get("uid")
+	if userID == "" {
+		http.Error(w, "user id is required", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		states := make([]desiredState, 0, 16)
+		for _, state := range server.deviceTwin.list(r.URL.Query().Get("di")) {
+			if server.deviceOwners.owner(state.DeviceID) == userID {
+				states = append(states, state)
+			}
+		}
+		writeJSON(w, http.StatusOK, states)
+	case http.MethodPut:
+		var state desiredState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if state.DeviceID == "" || state.Href == "" || state.Content == nil {
+			http.Error(w, "device id, href and content are required", http.StatusBadRequest)
+			return
+		}
+		if _, err := contentType2CoapContentFormat(state.Content.ContentType); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if server.deviceOwners.owner(state.DeviceID) != userID {
+			http.Error(w, "device is not owned by the user", http.StatusForbidden)
+			return
+		}
+		state = server.deviceTwin.set(state)
+		if session := server.clientContainer.findByDeviceID(state.DeviceID); session != nil {
+			reported, _, _ := session.loadShadow(state.DeviceID, state.Href, time.Duration(math.MaxInt64))
+			go server.reconcileDesiredState(session, state.DeviceID, state.Href, reported)
+		}
+		writeJSON(w, http.StatusOK, state)
+	case http.MethodDelete:
+		deviceID := r.URL.Query().Get("di")
+		if server.deviceOwners.owner(deviceID) != userID {
+			http.Error(w, "device is not owned by the user", http.StatusForbidden)
+			return
+		}
+		if !server.deviceTwin.remove(deviceID, r.URL.Query().Get("href")) {
+			http.Error(w, "desired state not found", http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,148 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/ugorji/go/codec"
+)
+
+func testCBORContent(t *testing.T, v interface{}) *resources.Content {
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(v); err != nil {
+		t.Fatalf("cannot encode %v: %v", v, err)
+	}
+	return &resources.Content{Data: data, ContentType: "application/vnd.ocf+cbor"}
+}
+
+func TestDesiredContentMatches(t *testing.T) {
+	desired := testCBORContent(t, map[string]interface{}{"value": true})
+	if !desiredContentMatches(desired, testCBORContent(t, map[string]interface{}{"value": true, "rt": []string{"oic.r.switch.binary"}})) {
+		t.Fatalf("reported content with additional properties doesn't match")
+	}
+	if desiredContentMatches(desired, testCBORContent(t, map[string]interface{}{"value": false})) {
+		t.Fatalf("different reported content matches")
+	}
+	if desiredContentMatches(desired, nil) {
+		t.Fatalf("missing reported content matches")
+	}
+	text := &resources.Content{Data: []byte("on"), ContentType: "text/plain"}
+	if !desiredContentMatches(text, &resources.Content{Data: []byte("on"), ContentType: "text/plain"}) {
+		t.Fatalf("equal text content doesn't match")
+	}
+}
+
+func TestDeviceTwinReported(t *testing.T) {
+	twin, err := newDeviceTwin("", 2)
+	if err != nil {
+		t.Fatalf("cannot create device twin: %v", err)
+	}
+	on := testCBORContent(t, map[string]interface{}{"value": true})
+	off := testCBORContent(t, map[string]interface{}{"value": false})
+	twin.set(desiredState{DeviceID: "a", Href: "/switch", Content: on})
+
+	if _, update := twin.reported("a", "/other", off); update {
+		t.Fatalf("resource without desired state is updated")
+	}
+	for i := 0; i < 2; i++ {
+		if _, update := twin.reported("a", "/switch", off); !update {
+			t.Fatalf("device is not updated in attempt %v", i)
+		}
+		if _, update := twin.reported("a", "/switch", off); update {
+			t.Fatalf("device is updated while the previous update is in progress")
+		}
+		twin.updated("a", "/switch", nil)
+	}
+	if _, update := twin.reported("a", "/switch", off); update {
+		t.Fatalf("device is updated after max attempts")
+	}
+	if states := twin.list("a"); len(states) != 1 || states[0].Status != twinConflict {
+		t.Fatalf("unexpected states %v", states)
+	}
+
+	twin.set(desiredState{DeviceID: "a", Href: "/switch", Content: on})
+	if _, update := twin.reported("a", "/switch", off); !update {
+		t.Fatalf("device is not updated after desired state changed")
+	}
+	twin.updated("a", "/switch", errors.New("timeout"))
+	if states := twin.list("a"); states[0].Status != twinFailed || states[0].LastError != "timeout" {
+		t.Fatalf("unexpected states %v", states)
+	}
+	if _, update := twin.reported("a", "/switch", on); update {
+		t.Fatalf("converged device is updated")
+	}
+	if states := twin.list(""); states[0].Status != twinConverged {
+		t.Fatalf("unexpected states %v", states)
+	}
+}
+
+func TestReconcileDesiredStateWithoutReportedContent(t *testing.T) {
+	twin, err := newDeviceTwin("", 2)
+	if err != nil {
+		t.Fatalf("cannot create device twin: %v", err)
+	}
+	server := &Server{deviceTwin: twin}
+	twin.set(desiredState{DeviceID: "a", Href: "/switch", Content: testCBORContent(t, map[string]interface{}{"value": true})})
+	server.reconcileDesiredState(nil, "a", "/switch", nil)
+	if states := twin.list("a"); len(states) != 1 || states[0].Attempts != 0 || states[0].Status == twinConflict {
+		t.Fatalf("device without reported content was updated: %v", states)
+	}
+}
+
+func TestAdminDesiredStateHandler(t *testing.T) {
+	s := testBulkCommandServer(t)
+	s.deviceOwners.set("a", "u")
+	handler := s.newAdminHandler()
+	data, err := json.Marshal(desiredState{DeviceID: "a", Href: "/switch", Content: testCBORContent(t, map[string]interface{}{"value": true})})
+	if err != nil {
+		t.Fatalf("cannot encode desired state: %v", err)
+	}
+	body := string(data)
+
+	tbl := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		token  string
+		status int
+		states int
+	}{
+		{"WithoutToken", http.MethodGet, adminDesiredState + "?uid=u", "", "", http.StatusUnauthorized, 0},
+		{"MissingUser", http.MethodGet, adminDesiredState, "", "secret", http.StatusBadRequest, 0},
+		{"PutOtherUser", http.MethodPut, adminDesiredState + "?uid=v", body, "secret", http.StatusForbidden, 0},
+		{"Put", http.MethodPut, adminDesiredState + "?uid=u", body, "secret", http.StatusOK, 0},
+		{"List", http.MethodGet, adminDesiredState + "?uid=u", "", "secret", http.StatusOK, 1},
+		{"ListOtherUser", http.MethodGet, adminDesiredState + "?uid=v", "", "secret", http.StatusOK, 0},
+		{"DeleteOtherUser", http.MethodDelete, adminDesiredState + "?uid=v&di=a&href=/switch", "", "secret", http.StatusForbidden, 0},
+		{"Delete", http.MethodDelete, adminDesiredState + "?uid=u&di=a&href=/switch", "", "secret", http.StatusNoContent, 0},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status code %v", w.Code)
+			}
+			if tt.method != http.MethodGet || w.Code != http.StatusOK {
+				return
+			}
+			var states []desiredState
+			if err := json.NewDecoder(w.Body).Decode(&states); err != nil {
+				t.Fatalf("cannot decode response: %v", err)
+			}
+			if len(states) != tt.states {
+				t.Fatalf("unexpected desired states %+v", states)
+			}
+		})
+	}
+}
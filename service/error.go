@@ -7,3 +7,6 @@ func (e Error) Error() string { return string(e) }
 
 //ErrEmptyCARootPool ca root pool is empty
 var ErrEmptyCARootPool = Error("CA Root pool is empty.")
+
+//ErrDeviceRequestTimeout device didn't respond within the device request timeout
+var ErrDeviceRequestTimeout = Error("Device didn't respond in time.")
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/go-ocf/kit/log"
+)
+
+//journalCompactionMin number of appended records before the journal can be compacted
+const journalCompactionMin = 1024
+
+//journal persists changes of a store as JSON records appended to a file, so a change costs one write of its record.
+//The file is rewritten from a snapshot of the store when appended records outnumber records of the last snapshot.
+//Methods must be called under the lock of the store.
+type journal struct {
+	file     string // file of the journal, in-memory only when empty
+	out      *os.File
+	appended int // records appended since the last snapshot
+	live     int // records of the last snapshot
+}
+
+//openJournal passes records of the file in order to replay and opens the file for appending, records which cannot be replayed are skipped
+func openJournal(file string, replay func(record []byte) error) (*journal, error) {
+	j := &journal{file: file}
+	if file == "" {
+		return j, nil
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return nil, err
+	}
+	for _, record := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(record)) == 0 {
+			continue
+		}
+		if err := replay(record); err != nil {
+			log.Errorf("Cannot replay record of journal '%v': %v", file, err)
+			continue
+		}
+		j.appended++
+	}
+	j.out, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+//write appends record of a change which was already applied to the store, snapshot returns records of the whole store
+func (j *journal) write(record interface{}, snapshot func() []interface{}) {
+	if j.file == "" {
+		return
+	}
+	if j.appended >= journalCompactionMin && j.appended > j.live {
+		j.compact(snapshot())
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		log.Errorf("Cannot encode record of journal '%v': %v", j.file, err)
+		return
+	}
+	if _, err := j.out.Write(append(data, '\n')); err != nil {
+		log.Errorf("Cannot write journal '%v': %v", j.file, err)
+		return
+	}
+	j.appended++
+}
+
+func (j *journal) compact(records []interface{}) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			log.Errorf("Cannot encode record of journal '%v': %v", j.file, err)
+			return
+		}
+	}
+	if err := writeFileAtomic(j.file, buf.Bytes()); err != nil {
+		log.Errorf("Cannot compact journal '%v': %v", j.file, err)
+		return
+	}
+	out, err := os.OpenFile(j.file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Errorf("Cannot open journal '%v': %v", j.file, err)
+		return
+	}
+	j.out.Close()
+	j.out = out
+	j.appended = 0
+	j.live = len(records)
+}
+
+func writeFileAtomic(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+//testDataDir creates a temporary directory for persisted stores
+func testDataDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gotesttmp")
+	if err != nil {
+		t.Fatalf("%v", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+//testStoreReload fills a store persisted in a temporary file and checks the store reopened from the file
+func testStoreReload(t *testing.T, fill, check func(file string)) {
+	dir, remove := testDataDir(t)
+	defer remove()
+	file := filepath.Join(dir, "store.jsonl")
+	fill(file)
+	check(file)
+}
+
+func TestJournalCompaction(t *testing.T) {
+	dir, remove := testDataDir(t)
+	defer remove()
+	file := filepath.Join(dir, "journal.jsonl")
+
+	values := make(map[string]int)
+	replay := func(data []byte) error {
+		var record map[string]int
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		for k, v := range record {
+			values[k] = v
+		}
+		return nil
+	}
+	snapshot := func() []interface{} {
+		records := make([]interface{}, 0, len(values))
+		for k, v := range values {
+			records = append(records, map[string]int{k: v})
+		}
+		return records
+	}
+	j, err := openJournal(file, replay)
+	if err != nil {
+		t.Fatalf("cannot open journal: %v", err)
+	}
+	for i := 0; i < 3*journalCompactionMin; i++ {
+		key := strconv.Itoa(i % 10)
+		values[key] = i
+		j.write(map[string]int{key: i}, snapshot)
+	}
+	if j.appended >= journalCompactionMin || j.live != 10 {
+		t.Fatalf("journal was not compacted: %v appended, %v live", j.appended, j.live)
+	}
+
+	expected := values
+	values = make(map[string]int)
+	if _, err := openJournal(file, replay); err != nil {
+		t.Fatalf("cannot reopen journal: %v", err)
+	}
+	if len(values) != len(expected) || values["9"] != expected["9"] {
+		t.Fatalf("unexpected values %v after reopen, expected %v", values, expected)
+	}
+}
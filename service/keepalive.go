@@ -1,73 +1,178 @@
 package service
 
 import (
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-ocf/go-coap"
 	"github.com/go-ocf/kit/log"
 )
 
-//Keepalive setup of keepalive
+//Keepalive setup of keepalive, the connection is checked by the keepalive scheduler of the server
 type Keepalive struct {
-	time     time.Duration
-	interval time.Duration
-	retry    int
-	client   *coap.ClientCommander
+	time      time.Duration // guarded by mutex, overrides of the device change it
+	interval  time.Duration
+	retry     int
+	name      string
+	ping      func(timeout time.Duration) error
+	close     func() error
+	scheduler *keepaliveScheduler
 
-	doneChan chan interface{}
+	lastActivity   int64         // unix time in nanoseconds of the last traffic from the device
+	deviceInterval time.Duration // interval of /oic/ping negotiated by the device, zero when the device doesn't ping
+	lastDevicePing time.Time
+	mutex          sync.Mutex
+	timeoutCount   int // only check touches it, the keepalive is not in the queue meanwhile
+	quality        linkQuality
+	onPing         func(info linkQualityInfo) // called after each ping, optional
+
+	due    time.Time // guarded by mutex of scheduler
+	index  int
+	closed bool
 }
 
-//Done wake and end goroutine
+//Done remove keepalive from scheduler
 func (k *Keepalive) Done() {
-	var v interface{}
-	k.doneChan <- v
+	k.scheduler.remove(k)
 }
 
 //Terminate terminate connection by keepalive
 func (k *Keepalive) Terminate() {
-	log.Infof("Terminate connection %v by keepalive %v", k.client.RemoteAddr(), k)
-	k.client.Close()
+	log.Infof("Terminate connection %v by keepalive", k.name)
+	keepaliveMetric.Add("terminated", 1)
+	if err := k.close(); err != nil {
+		log.Errorf("Cannot close connection %v: %v", k.name, err)
+	}
+}
+
+//touch records traffic from the device, the keepalive doesn't ping a device with recent traffic
+func (k *Keepalive) touch() {
+	atomic.StoreInt64(&k.lastActivity, time.Now().UnixNano())
+}
+
+func (k *Keepalive) lastActivityTime() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&k.lastActivity))
+}
+
+//devicePing records keepalive of the device received by /oic/ping and restarts the idle timer of the gateway
+func (k *Keepalive) devicePing(interval time.Duration) {
+	k.touch()
+	k.mutex.Lock()
+	k.deviceInterval = interval
+	k.lastDevicePing = time.Now()
+	k.mutex.Unlock()
+	k.scheduler.reschedule(k, k.idleTime())
+}
+
+//configure applies keepalive settings to the live connection
+func (k *Keepalive) configure(keepaliveTime, interval time.Duration, retry int) {
+	k.mutex.Lock()
+	k.time = keepaliveTime
+	k.interval = interval
+	k.retry = retry
+	k.mutex.Unlock()
+	k.scheduler.advance(k, k.idleTime())
+}
+
+func (k *Keepalive) settings() (keepaliveTime, interval time.Duration, retry int) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.time, k.interval, k.retry
+}
+
+func (k *Keepalive) setPingCallback(onPing func(info linkQualityInfo)) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.onPing = onPing
+}
+
+func (k *Keepalive) pingCallback() func(info linkQualityInfo) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.onPing
 }
 
-func (k *Keepalive) run() {
-	timeoutCount := 0
-	for {
-		waitTime := k.time
-		if timeoutCount > 0 {
-			waitTime = k.interval
+//missedDevicePings returns number of intervals elapsed without ping of the device
+func (k *Keepalive) missedDevicePings(now time.Time) int {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.deviceInterval <= 0 {
+		return 0
+	}
+	return int(now.Sub(k.lastDevicePing) / k.deviceInterval)
+}
+
+func (k *Keepalive) idleTime() time.Duration {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.deviceInterval > 0 && k.deviceInterval < k.time {
+		return k.deviceInterval
+	}
+	return k.time
+}
+
+//check pings the device when it was idle and schedules the next check
+func (k *Keepalive) check() {
+	now := time.Now()
+	_, interval, retry := k.settings()
+	if misses := k.missedDevicePings(now); misses >= retry {
+		log.Errorf("Device %v missed %v pings", k.name, misses)
+		k.Terminate()
+		return
+	}
+	idle := k.idleTime()
+	if k.timeoutCount == 0 {
+		if elapsed := now.Sub(k.lastActivityTime()); elapsed < idle {
+			keepaliveMetric.Add("skipped", 1)
+			k.scheduler.schedule(k, idle-elapsed)
+			return
 		}
-		select {
-		case <-k.doneChan:
+	}
+	start := time.Now()
+	err := k.ping(time.Second)
+	k.quality.record(time.Since(start), err)
+	if onPing := k.pingCallback(); onPing != nil && (err == nil || err == coap.ErrTimeout) {
+		onPing(k.quality.info())
+	}
+	switch {
+	case err == nil:
+		keepaliveMetric.Add("sent", 1)
+		k.touch()
+		k.timeoutCount = 0
+		k.scheduler.schedule(k, idle)
+	case err == coap.ErrTimeout:
+		log.Errorf("Cannot send PING to %v: %v", k.name, err)
+		keepaliveMetric.Add("timeout", 1)
+		k.timeoutCount++
+		if k.timeoutCount >= retry {
+			k.Terminate()
 			return
-		case <-time.After(time.Second * waitTime):
-			if err := k.client.Ping(time.Second); err != nil {
-				log.Errorf("Cannot send PING to %v: %v", k.client.RemoteAddr(), err)
-				if err == coap.ErrTimeout {
-					timeoutCount++
-					if timeoutCount >= k.retry {
-						k.Terminate()
-						return
-					}
-				} else {
-					//other error then timeout - connection was closed
-					return
-				}
-
-			}
-			timeoutCount = 0
 		}
+		k.scheduler.schedule(k, interval)
+	default:
+		//other error then timeout - connection was closed
+		log.Errorf("Cannot send PING to %v: %v", k.name, err)
 	}
 }
 
-//NewKeepalive create new Keepalive instance and start check of connection
-func NewKeepalive(server *Server, client *coap.ClientCommander) *Keepalive {
+func newKeepalive(scheduler *keepaliveScheduler, name string, ping func(timeout time.Duration) error, close func() error, keepaliveTime, interval time.Duration, retry int) *Keepalive {
 	k := &Keepalive{
-		time:     server.keepaliveTime,
-		interval: server.keepaliveInterval,
-		retry:    server.keepaliveRetry,
-		client:   client,
-		doneChan: make(chan interface{}, 1),
+		time:      keepaliveTime,
+		interval:  interval,
+		retry:     retry,
+		name:      name,
+		ping:      ping,
+		close:     close,
+		scheduler: scheduler,
+		index:     -1,
 	}
-	go k.run()
+	k.touch()
+	scheduler.schedule(k, keepaliveTime)
 	return k
 }
+
+//NewKeepalive create new Keepalive instance and start check of connection
+func NewKeepalive(server *Server, client *coap.ClientCommander) *Keepalive {
+	return newKeepalive(server.keepaliveScheduler, client.RemoteAddr().String(), client.Ping, client.Close, server.keepaliveTime, server.keepaliveInterval, server.keepaliveRetry)
+}
@@ -0,0 +1,243 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const adminKeepalive = "/api/v1/keepalive"
+
+//keepaliveDuration duration encoded in JSON as a string like "90s"
+type keepaliveDuration time.Duration
+
+func (d keepaliveDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *keepaliveDuration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90s\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = keepaliveDuration(v)
+	return nil
+}
+
+//keepaliveOverride keepalive settings of a device or of all devices of a user, zero values keep the global settings
+type keepaliveOverride struct {
+	DeviceID string            `json:"di,omitempty"`
+	UserID   string            `json:"uid,omitempty"`
+	Time     keepaliveDuration `json:"time,omitempty"`     // time between keepalives of idle connection
+	Interval keepaliveDuration `json:"interval,omitempty"` // time between retransmissions of unacknowledged keepalive
+	Retry    int               `json:"retry,omitempty"`
+}
+
+//keepaliveOverrideRecord is a set or removed override in the journal of overrides
+type keepaliveOverrideRecord struct {
+	Override *keepaliveOverride `json:"override"`
+	Removed  bool               `json:"removed,omitempty"`
+}
+
+//keepaliveOverrides keepalive settings per device and per user, the override of the device takes precedence
+type keepaliveOverrides struct {
+	journal *journal
+	devices map[string]keepaliveOverride
+	users   map[string]keepaliveOverride
+	mutex   sync.Mutex
+}
+
+func newKeepaliveOverrides(file string) (*keepaliveOverrides, error) {
+	o := &keepaliveOverrides{
+		devices: make(map[string]keepaliveOverride),
+		users:   make(map[string]keepaliveOverride),
+	}
+	var err error
+	o.journal, err = openJournal(file, o.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open keepalive overrides '%v': %v", file, err)
+	}
+	return o, nil
+}
+
+func (o *keepaliveOverrides) replay(data []byte) error {
+	var r keepaliveOverrideRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Override == nil {
+		return fmt.Errorf("missing keepalive override")
+	}
+	if err := validateKeepaliveOverride(*r.Override); err != nil {
+		return err
+	}
+	if r.Removed {
+		o.removeLocked(r.Override.DeviceID, r.Override.UserID)
+	} else {
+		o.setLocked(*r.Override)
+	}
+	return nil
+}
+
+func (o *keepaliveOverrides) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(o.devices)+len(o.users))
+	for _, overrides := range []map[string]keepaliveOverride{o.users, o.devices} {
+		for _, override := range overrides {
+			override := override
+			records = append(records, keepaliveOverrideRecord{Override: &override})
+		}
+	}
+	return records
+}
+
+func validateKeepaliveOverride(override keepaliveOverride) error {
+	if (override.DeviceID == "") == (override.UserID == "") {
+		return fmt.Errorf("exactly one of di and uid is required")
+	}
+	if override.Time < 0 || override.Interval < 0 || override.Retry < 0 {
+		return fmt.Errorf("negative keepalive settings")
+	}
+	return nil
+}
+
+func (o *keepaliveOverrides) setLocked(override keepaliveOverride) {
+	if override.DeviceID != "" {
+		o.devices[override.DeviceID] = override
+	} else {
+		o.users[override.UserID] = override
+	}
+}
+
+func (o *keepaliveOverrides) set(override keepaliveOverride) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	o.setLocked(override)
+	o.journal.write(keepaliveOverrideRecord{Override: &override}, o.snapshotLocked)
+}
+
+func (o *keepaliveOverrides) removeLocked(deviceID, userID string) bool {
+	overrides, key := o.devices, deviceID
+	if deviceID == "" {
+		overrides, key = o.users, userID
+	}
+	if _, ok := overrides[key]; !ok {
+		return false
+	}
+	delete(overrides, key)
+	return true
+}
+
+func (o *keepaliveOverrides) remove(deviceID, userID string) bool {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if !o.removeLocked(deviceID, userID) {
+		return false
+	}
+	o.journal.write(keepaliveOverrideRecord{Override: &keepaliveOverride{DeviceID: deviceID, UserID: userID}, Removed: true}, o.snapshotLocked)
+	return true
+}
+
+func (o *keepaliveOverrides) list() []keepaliveOverride {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	list := make([]keepaliveOverride, 0, len(o.devices)+len(o.users))
+	for _, override := range o.users {
+		list = append(list, override)
+	}
+	for _, override := range o.devices {
+		list = append(list, override)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].UserID == list[j].UserID {
+			return list[i].DeviceID < list[j].DeviceID
+		}
+		return list[i].UserID < list[j].UserID
+	})
+	return list
+}
+
+func applyKeepaliveOverride(override keepaliveOverride, keepaliveTime, interval *time.Duration, retry *int) {
+	if override.Time > 0 {
+		*keepaliveTime = time.Duration(override.Time)
+	}
+	if override.Interval > 0 {
+		*interval = time.Duration(override.Interval)
+	}
+	if override.Retry > 0 {
+		*retry = override.Retry
+	}
+}
+
+//resolve returns keepalive settings of the device of the user
+func (o *keepaliveOverrides) resolve(deviceID, userID string, keepaliveTime, interval time.Duration, retry int) (time.Duration, time.Duration, int) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if override, ok := o.users[userID]; ok && userID != "" {
+		applyKeepaliveOverride(override, &keepaliveTime, &interval, &retry)
+	}
+	if override, ok := o.devices[deviceID]; ok && deviceID != "" {
+		applyKeepaliveOverride(override, &keepaliveTime, &interval, &retry)
+	}
+	return keepaliveTime, interval, retry
+}
+
+//applyKeepaliveOverrides sets keepalive of the session by overrides of its device and user
+func (server *Server) applyKeepaliveOverrides(session *Session) {
+	authContext := session.loadAuthorizationContext()
+	keepaliveTime, interval, retry := server.keepaliveOverrides.resolve(authContext.GetDeviceId(), authContext.GetUserId(), server.keepaliveTime, server.keepaliveInterval, server.keepaliveRetry)
+	session.keepalive.configure(keepaliveTime, interval, retry)
+}
+
+func (server *Server) adminKeepaliveHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, server.keepaliveOverrides.list())
+	case http.MethodPut:
+		var override keepaliveOverride
+		if err := json.NewDecoder(r.Body).Decode(&override); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validateKeepaliveOverride(override); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		server.keepaliveOverrides.set(override)
+		server.applyKeepaliveOverridesOf(override.DeviceID, override.UserID)
+		writeJSON(w, http.StatusOK, override)
+	case http.MethodDelete:
+		deviceID, userID := r.URL.Query().Get("di"), r.URL.Query().Get("uid")
+		if err := validateKeepaliveOverride(keepaliveOverride{DeviceID: deviceID, UserID: userID}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if !server.keepaliveOverrides.remove(deviceID, userID) {
+			http.Error(w, "keepalive override not found", http.StatusNotFound)
+			return
+		}
+		server.applyKeepaliveOverridesOf(deviceID, userID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//applyKeepaliveOverridesOf applies changed override to live sessions of the device or of the user
+func (server *Server) applyKeepaliveOverridesOf(deviceID, userID string) {
+	if deviceID != "" {
+		if session := server.clientContainer.findByDeviceID(deviceID); session != nil {
+			server.applyKeepaliveOverrides(session)
+		}
+		return
+	}
+	for _, session := range server.clientContainer.findByUserID(userID) {
+		server.applyKeepaliveOverrides(session)
+	}
+}
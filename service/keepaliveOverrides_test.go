@@ -0,0 +1,105 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveOverridesResolve(t *testing.T) {
+	testStoreReload(t, func(file string) {
+		o, err := newKeepaliveOverrides(file)
+		if err != nil {
+			t.Fatalf("cannot create overrides: %v", err)
+		}
+		o.set(keepaliveOverride{UserID: "u", Time: keepaliveDuration(10 * time.Minute), Retry: 2})
+		o.set(keepaliveOverride{DeviceID: "battery", Time: keepaliveDuration(2 * time.Hour)})
+	}, func(file string) {
+		o, err := newKeepaliveOverrides(file)
+		if err != nil {
+			t.Fatalf("cannot reload overrides: %v", err)
+		}
+		if keepaliveTime, interval, retry := o.resolve("hub", "u", time.Hour, 5*time.Second, 5); keepaliveTime != 10*time.Minute || interval != 5*time.Second || retry != 2 {
+			t.Fatalf("unexpected settings of user %v %v %v", keepaliveTime, interval, retry)
+		}
+		if keepaliveTime, _, retry := o.resolve("battery", "u", time.Hour, 5*time.Second, 5); keepaliveTime != 2*time.Hour || retry != 2 {
+			t.Fatalf("unexpected settings of device %v %v", keepaliveTime, retry)
+		}
+		if !o.remove("battery", "") || o.remove("battery", "") {
+			t.Fatalf("unexpected result of remove")
+		}
+		if keepaliveTime, _, _ := o.resolve("battery", "other", time.Hour, 5*time.Second, 5); keepaliveTime != time.Hour {
+			t.Fatalf("unexpected settings without override %v", keepaliveTime)
+		}
+	})
+	if err := validateKeepaliveOverride(keepaliveOverride{DeviceID: "a", UserID: "u"}); err == nil {
+		t.Fatalf("override of device and user was accepted")
+	}
+}
+
+func TestKeepaliveOverrideDuration(t *testing.T) {
+	var override keepaliveOverride
+	if err := json.Unmarshal([]byte(`{"di":"a","time":"90s","interval":"1m"}`), &override); err != nil {
+		t.Fatalf("cannot decode override: %v", err)
+	}
+	if time.Duration(override.Time) != 90*time.Second || time.Duration(override.Interval) != time.Minute {
+		t.Fatalf("unexpected override %+v", override)
+	}
+	if err := json.Unmarshal([]byte(`{"di":"a","time":90}`), &override); err == nil {
+		t.Fatalf("duration in seconds was accepted")
+	}
+	if data, _ := json.Marshal(keepaliveOverride{DeviceID: "a", Time: keepaliveDuration(90 * time.Second)}); string(data) != `{"di":"a","time":"1m30s"}` {
+		t.Fatalf("unexpected encoded override %s", data)
+	}
+}
+
+func TestAdminKeepaliveHandler(t *testing.T) {
+	os.Setenv("NETWORK", "tcp")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	s.AdminToken = "secret"
+	handler := s.newAdminHandler()
+	tbl := []struct {
+		name  string
+		token string
+		body  string
+		code  int
+	}{
+		{"WithoutToken", "", `{"di":"a","time":"90s"}`, http.StatusUnauthorized},
+		{"InvalidToken", "other", `{"di":"a","time":"90s"}`, http.StatusUnauthorized},
+		{"Set", "secret", `{"di":"a","time":"90s"}`, http.StatusOK},
+		{"Seconds", "secret", `{"di":"a","time":90}`, http.StatusBadRequest},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, adminKeepalive, strings.NewReader(tt.body))
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != tt.code {
+				t.Fatalf("unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
+
+func TestKeepaliveConfigureLiveSession(t *testing.T) {
+	s := newKeepaliveScheduler(0, 1)
+	closed := 0
+	k := testKeepalive(s, func(time.Duration) error { return nil }, &closed)
+	k.configure(time.Minute, time.Second, 3)
+	if _, next := s.popDue(time.Now()); next > time.Minute {
+		t.Fatalf("keepalive was not rescheduled, next check in %v", next)
+	}
+	if keepaliveTime, _, retry := k.settings(); keepaliveTime != time.Minute || retry != 3 {
+		t.Fatalf("unexpected settings %v %v", keepaliveTime, retry)
+	}
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"container/heap"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+//keepaliveQueue heap of keepalives ordered by time of the next check
+type keepaliveQueue []*Keepalive
+
+func (q keepaliveQueue) Len() int           { return len(q) }
+func (q keepaliveQueue) Less(i, j int) bool { return q[i].due.Before(q[j].due) }
+func (q keepaliveQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *keepaliveQueue) Push(x interface{}) {
+	k := x.(*Keepalive)
+	k.index = len(*q)
+	*q = append(*q, k)
+}
+
+func (q *keepaliveQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	k := old[n-1]
+	old[n-1] = nil
+	k.index = -1
+	*q = old[:n-1]
+	return k
+}
+
+//keepaliveScheduler one timer shared by keepalives of all sessions, due keepalives are checked by a bounded pool of workers
+type keepaliveScheduler struct {
+	jitter  float64 // maximal random prolongation of delay as fraction of the delay
+	workers int
+
+	queue keepaliveQueue
+	mutex sync.Mutex
+	wake  chan struct{}
+	done  chan struct{}
+}
+
+func newKeepaliveScheduler(jitter float64, workers int) *keepaliveScheduler {
+	if workers <= 0 {
+		workers = 1
+	}
+	return &keepaliveScheduler{
+		jitter:  jitter,
+		workers: workers,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+}
+
+func (s *keepaliveScheduler) jittered(delay time.Duration) time.Duration {
+	if s.jitter <= 0 || delay <= 0 {
+		return delay
+	}
+	return delay + time.Duration(rand.Float64()*s.jitter*float64(delay))
+}
+
+func (s *keepaliveScheduler) wakeUp() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+//schedule sets the next check of keepalive after delay
+func (s *keepaliveScheduler) schedule(k *Keepalive, delay time.Duration) {
+	s.mutex.Lock()
+	if k.closed {
+		s.mutex.Unlock()
+		return
+	}
+	k.due = time.Now().Add(s.jittered(delay))
+	if k.index >= 0 {
+		heap.Fix(&s.queue, k.index)
+	} else {
+		heap.Push(&s.queue, k)
+	}
+	first := s.queue[0] == k
+	s.mutex.Unlock()
+	if first {
+		s.wakeUp()
+	}
+}
+
+//advance moves the next check of keepalive earlier when it is scheduled later than after delay
+func (s *keepaliveScheduler) advance(k *Keepalive, delay time.Duration) {
+	s.mutex.Lock()
+	due := time.Now().Add(delay)
+	if k.closed || k.index < 0 || !k.due.After(due) {
+		s.mutex.Unlock()
+		return
+	}
+	k.due = due
+	heap.Fix(&s.queue, k.index)
+	first := s.queue[0] == k
+	s.mutex.Unlock()
+	if first {
+		s.wakeUp()
+	}
+}
+
+//reschedule sets the next check of queued keepalive after delay, the keepalive being checked is scheduled by the check
+func (s *keepaliveScheduler) reschedule(k *Keepalive, delay time.Duration) {
+	s.mutex.Lock()
+	if k.closed || k.index < 0 {
+		s.mutex.Unlock()
+		return
+	}
+	k.due = time.Now().Add(s.jittered(delay))
+	heap.Fix(&s.queue, k.index)
+	first := s.queue[0] == k
+	s.mutex.Unlock()
+	if first {
+		s.wakeUp()
+	}
+}
+
+func (s *keepaliveScheduler) remove(k *Keepalive) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	k.closed = true
+	if k.index >= 0 {
+		heap.Remove(&s.queue, k.index)
+	}
+}
+
+//popDue removes keepalives due at now from the queue and returns them with the time until the next one, negative when queue is empty
+func (s *keepaliveScheduler) popDue(now time.Time) ([]*Keepalive, time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var due []*Keepalive
+	for len(s.queue) > 0 && !s.queue[0].due.After(now) {
+		due = append(due, heap.Pop(&s.queue).(*Keepalive))
+	}
+	if len(s.queue) == 0 {
+		return due, -1
+	}
+	return due, s.queue[0].due.Sub(now)
+}
+
+func (s *keepaliveScheduler) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.queue)
+}
+
+func (s *keepaliveScheduler) run() {
+	checks := make(chan *Keepalive)
+	var workers sync.WaitGroup
+	for i := 0; i < s.workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for k := range checks {
+				k.check()
+			}
+		}()
+	}
+	defer workers.Wait()
+	defer close(checks)
+
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		due, next := s.popDue(time.Now())
+		for _, k := range due {
+			select {
+			case checks <- k:
+			case <-s.done:
+				return
+			}
+		}
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		var expired <-chan time.Time
+		if next >= 0 {
+			timer.Reset(next)
+			expired = timer.C
+		}
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-expired:
+		}
+	}
+}
+
+func (s *keepaliveScheduler) stop() {
+	close(s.done)
+}
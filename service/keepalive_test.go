@@ -0,0 +1,171 @@
+package service
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+func testKeepalive(scheduler *keepaliveScheduler, ping func(timeout time.Duration) error, closed *int) *Keepalive {
+	return newKeepalive(scheduler, "device", ping, func() error {
+		*closed++
+		return nil
+	}, time.Hour, time.Second, 2)
+}
+
+func TestKeepaliveSkipsPingWithRecentTraffic(t *testing.T) {
+	s := newKeepaliveScheduler(0, 1)
+	pings, closed := 0, 0
+	k := testKeepalive(s, func(time.Duration) error {
+		pings++
+		return nil
+	}, &closed)
+	if due, _ := s.popDue(time.Now().Add(2 * time.Hour)); len(due) != 1 {
+		t.Fatalf("unexpected due keepalives %v", due)
+	}
+	k.check()
+	if pings != 0 || s.len() != 1 {
+		t.Fatalf("device with recent traffic was pinged %v times", pings)
+	}
+	k.lastActivity = 0
+	s.popDue(time.Now().Add(2 * time.Hour))
+	k.check()
+	if pings != 1 || s.len() != 1 {
+		t.Fatalf("idle device was pinged %v times", pings)
+	}
+	k.Done()
+	if s.len() != 0 {
+		t.Fatalf("keepalive was not removed")
+	}
+	s.schedule(k, 0)
+	if s.len() != 0 {
+		t.Fatalf("done keepalive was scheduled")
+	}
+}
+
+func TestKeepaliveTerminatesAfterRetries(t *testing.T) {
+	s := newKeepaliveScheduler(0, 1)
+	closed := 0
+	k := testKeepalive(s, func(time.Duration) error { return coap.ErrTimeout }, &closed)
+	k.lastActivity = 0
+	for i := 0; i < 2; i++ {
+		if due, _ := s.popDue(time.Now().Add(2 * time.Hour)); len(due) != 1 {
+			t.Fatalf("unexpected due keepalives %v", due)
+		}
+		k.check()
+	}
+	if closed != 1 || s.len() != 0 {
+		t.Fatalf("connection closed %v times, %v keepalives scheduled", closed, s.len())
+	}
+}
+
+func TestKeepaliveSchedulerOrder(t *testing.T) {
+	s := newKeepaliveScheduler(0.5, 1)
+	closed := 0
+	ping := func(time.Duration) error { return nil }
+	late := testKeepalive(s, ping, &closed)
+	early := testKeepalive(s, ping, &closed)
+	s.schedule(early, time.Minute)
+	if !early.due.After(time.Now().Add(time.Minute-time.Second)) || early.due.After(time.Now().Add(90*time.Second)) {
+		t.Fatalf("jitter out of range: %v", time.Until(early.due))
+	}
+	due, next := s.popDue(time.Now().Add(2 * time.Minute))
+	if len(due) != 1 || due[0] != early || next <= 0 {
+		t.Fatalf("unexpected due keepalives %v, next %v", due, next)
+	}
+	late.Done()
+	if _, next := s.popDue(time.Now()); next >= 0 {
+		t.Fatalf("queue is not empty")
+	}
+}
+
+func TestKeepaliveSchedulerWorkers(t *testing.T) {
+	s := newKeepaliveScheduler(0, 2)
+	var mutex sync.Mutex
+	active, maxActive, pings := 0, 0, 0
+	release := make(chan struct{})
+	ping := func(time.Duration) error {
+		mutex.Lock()
+		active++
+		pings++
+		if active > maxActive {
+			maxActive = active
+		}
+		mutex.Unlock()
+		<-release
+		mutex.Lock()
+		active--
+		mutex.Unlock()
+		return nil
+	}
+	closed := 0
+	for i := 0; i < 5; i++ {
+		k := testKeepalive(s, ping, &closed)
+		k.lastActivity = 0
+		s.schedule(k, 0)
+	}
+	go s.run()
+	defer s.stop()
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	if active != 2 {
+		t.Fatalf("unexpected concurrent checks %v", active)
+	}
+	mutex.Unlock()
+	close(release)
+	time.Sleep(100 * time.Millisecond)
+	mutex.Lock()
+	defer mutex.Unlock()
+	if pings != 5 || maxActive != 2 {
+		t.Fatalf("unexpected pings %v, concurrent checks %v", pings, maxActive)
+	}
+}
+
+func benchmarkKeepalives(s *keepaliveScheduler, n int) []*Keepalive {
+	closed := 0
+	ping := func(time.Duration) error { return nil }
+	keepalives := make([]*Keepalive, n)
+	for i := range keepalives {
+		keepalives[i] = testKeepalive(s, ping, &closed)
+	}
+	return keepalives
+}
+
+func BenchmarkKeepaliveSchedule100k(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		s := newKeepaliveScheduler(0.1, 1)
+		for _, k := range benchmarkKeepalives(s, 100000) {
+			k.Done()
+		}
+	}
+}
+
+func benchmarkKeepaliveChecks(b *testing.B, idle bool) {
+	s := newKeepaliveScheduler(0.1, 1)
+	keepalives := benchmarkKeepalives(s, 100000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, k := range keepalives {
+			if idle {
+				k.lastActivity = 0
+			}
+			s.schedule(k, 0)
+		}
+		due, _ := s.popDue(time.Now())
+		for _, k := range due {
+			k.check()
+		}
+	}
+}
+
+func BenchmarkKeepaliveCheckIdle100k(b *testing.B) {
+	benchmarkKeepaliveChecks(b, true)
+}
+
+func BenchmarkKeepaliveCheckRecentTraffic100k(b *testing.B) {
+	benchmarkKeepaliveChecks(b, false)
+}
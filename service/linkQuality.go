@@ -0,0 +1,170 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/ugorji/go/codec"
+)
+
+//connectivity quality of device is reported by the gateway in a virtual resource of the device
+const (
+	linkQualityHref         = "/oic/cloud/q"
+	linkQualityResourceType = "x.cloud.device.connectivity"
+)
+
+//linkQualityWeight weight of the last round trip time in the average
+const linkQualityWeight = 0.2
+
+//linkQualityChange relative change of the average round trip time which is reported
+const linkQualityChange = 0.2
+
+//linkQuality round trip times and timeouts of keepalive pings of a session
+type linkQuality struct {
+	pings    int
+	timeouts int
+	last     time.Duration
+	min      time.Duration
+	max      time.Duration
+	average  time.Duration // exponentially weighted moving average
+	mutex    sync.Mutex
+}
+
+type linkQualityInfo struct {
+	Pings    int     `json:"pings"`
+	Timeouts int     `json:"timeouts"`
+	LastRTT  float64 `json:"lastRttMs"`
+	MinRTT   float64 `json:"minRttMs"`
+	MaxRTT   float64 `json:"maxRttMs"`
+	AvgRTT   float64 `json:"avgRttMs"`
+}
+
+func pingRTTBucket(rtt time.Duration) string {
+	switch {
+	case rtt < 10*time.Millisecond:
+		return "<10ms"
+	case rtt < 100*time.Millisecond:
+		return "<100ms"
+	case rtt < time.Second:
+		return "<1s"
+	}
+	return ">=1s"
+}
+
+//record updates statistics by result of a ping, failures other then timeout are ignored
+func (q *linkQuality) record(rtt time.Duration, err error) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	switch {
+	case err == coap.ErrTimeout:
+		q.timeouts++
+	case err == nil:
+		pingRTTMetric.Add(pingRTTBucket(rtt), 1)
+		q.pings++
+		q.last = rtt
+		if q.pings == 1 {
+			q.min, q.max, q.average = rtt, rtt, rtt
+			return
+		}
+		if rtt < q.min {
+			q.min = rtt
+		}
+		if rtt > q.max {
+			q.max = rtt
+		}
+		q.average += time.Duration(linkQualityWeight * float64(rtt-q.average))
+	}
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (q *linkQuality) info() linkQualityInfo {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	return linkQualityInfo{
+		Pings:    q.pings,
+		Timeouts: q.timeouts,
+		LastRTT:  milliseconds(q.last),
+		MinRTT:   milliseconds(q.min),
+		MaxRTT:   milliseconds(q.max),
+		AvgRTT:   milliseconds(q.average),
+	}
+}
+
+//linkQualityReporter limits reports of connectivity quality of a session to material changes at most once per interval, one report is sent at a time
+type linkQualityReporter struct {
+	interval  time.Duration
+	last      linkQualityInfo
+	lastTime  time.Time
+	published bool // the resource was published by the first report
+	sending   bool
+	mutex     sync.Mutex
+}
+
+func newLinkQualityReporter(interval time.Duration) *linkQualityReporter {
+	return &linkQualityReporter{interval: interval}
+}
+
+//changedLocked returns true when timeouts occurred or the average round trip time changed materially since the last report
+func (r *linkQualityReporter) changedLocked(info linkQualityInfo) bool {
+	if info.Timeouts != r.last.Timeouts {
+		return true
+	}
+	if r.last.AvgRTT == 0 {
+		return info.AvgRTT != 0
+	}
+	change := (info.AvgRTT - r.last.AvgRTT) / r.last.AvgRTT
+	return change >= linkQualityChange || change <= -linkQualityChange
+}
+
+//begin returns true when info should be reported, publish is true when the resource must be published first
+func (r *linkQualityReporter) begin(info linkQualityInfo, now time.Time) (publish bool, report bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.sending {
+		return false, false
+	}
+	if r.published && (now.Sub(r.lastTime) < r.interval || !r.changedLocked(info)) {
+		return false, false
+	}
+	r.sending = true
+	return !r.published, true
+}
+
+//end records result of the report started by begin
+func (r *linkQualityReporter) end(info linkQualityInfo, now time.Time, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sending = false
+	if err != nil {
+		return
+	}
+	r.published = true
+	r.last = info
+	r.lastTime = now
+}
+
+//reportLinkQuality sends connectivity quality of the device to resource aggregate, the resource is published with the first report
+func reportLinkQuality(server *Server, authContext commands.AuthorizationContext, publish bool, info linkQualityInfo) error {
+	res := virtualResource(authContext.GetDeviceId(), linkQualityHref, linkQualityResourceType)
+	if publish {
+		if err := publishVirtualResource(server, authContext, res); err != nil {
+			return err
+		}
+	}
+	var data []byte
+	if err := codec.NewEncoderBytes(&data, new(codec.CborHandle)).Encode(info); err != nil {
+		return fmt.Errorf("cannot encode connectivity quality of device %v: %v", res.DeviceId, err)
+	}
+	return notifyResourceChanged(server, authContext, res, &resources.Content{
+		Data:              data,
+		ContentType:       coapContentFormat2ContentType(int32(coap.AppOcfCbor)),
+		CoapContentFormat: int32(coap.AppOcfCbor),
+	})
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+func TestLinkQualityRecord(t *testing.T) {
+	var q linkQuality
+	q.record(10*time.Millisecond, nil)
+	q.record(20*time.Millisecond, nil)
+	q.record(0, coap.ErrTimeout)
+	q.record(0, errors.New("closed"))
+	info := q.info()
+	if info.Pings != 2 || info.Timeouts != 1 {
+		t.Fatalf("unexpected counts %+v", info)
+	}
+	if info.MinRTT != 10 || info.MaxRTT != 20 || info.LastRTT != 20 || info.AvgRTT != 12 {
+		t.Fatalf("unexpected round trip times %+v", info)
+	}
+}
+
+func TestKeepaliveReportsLinkQuality(t *testing.T) {
+	closed := 0
+	k := testKeepalive(newKeepaliveScheduler(0, 1), func(time.Duration) error { return nil }, &closed)
+	reports := 0
+	k.setPingCallback(func(info linkQualityInfo) {
+		reports++
+		if info.Pings != 1 {
+			t.Fatalf("unexpected pings %v", info.Pings)
+		}
+	})
+	k.lastActivity = 0
+	k.check()
+	if reports != 1 {
+		t.Fatalf("unexpected reports %v", reports)
+	}
+}
+
+func TestLinkQualityReporter(t *testing.T) {
+	r := newLinkQualityReporter(time.Minute)
+	now := time.Now()
+	info := linkQualityInfo{Pings: 1, AvgRTT: 100}
+	if publish, report := r.begin(info, now); !publish || !report {
+		t.Fatalf("first report: publish %v, report %v", publish, report)
+	}
+	if _, report := r.begin(info, now); report {
+		t.Fatalf("report started while another one is sent")
+	}
+	r.end(info, now, nil)
+	tests := []struct {
+		name   string
+		info   linkQualityInfo
+		after  time.Duration
+		report bool
+	}{
+		{"Unchanged", linkQualityInfo{Pings: 5, AvgRTT: 110}, 2 * time.Minute, false},
+		{"ChangedEarly", linkQualityInfo{Pings: 5, AvgRTT: 200}, time.Second, false},
+		{"TimeoutEarly", linkQualityInfo{Pings: 5, Timeouts: 1, AvgRTT: 100}, time.Second, false},
+		{"Changed", linkQualityInfo{Pings: 5, AvgRTT: 200}, 2 * time.Minute, true},
+		{"Timeout", linkQualityInfo{Pings: 5, Timeouts: 1, AvgRTT: 100}, 2 * time.Minute, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publish, report := r.begin(tt.info, now.Add(tt.after))
+			if publish || report != tt.report {
+				t.Fatalf("unexpected publish %v, report %v", publish, report)
+			}
+			if report {
+				r.end(tt.info, now, errors.New("unavailable"))
+			}
+		})
+	}
+}
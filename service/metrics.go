@@ -0,0 +1,26 @@
+package service
+
+import "expvar"
+
+//metrics of the gateway, they are exposed by the admin server at /debug/vars
+var (
+	rdSelectionsMetric           = expvar.NewMap("rdSelections")               // [selectionCriteria:reason]count
+	deviceErrorResponsesMetric   = expvar.NewMap("deviceErrorResponses")       // [operation:code]count
+	pollsMetric                  = expvar.NewMap("polls")                      // [valid|content|error]count
+	observationsMetric           = expvar.NewMap("observations")               // [active|retrying|failed]count of transitions
+	throttledNotificationsMetric = expvar.NewInt("throttledNotifications")     // count of notifications superseded within pmin
+	observeNotificationsMetric   = expvar.NewMap("observeNotifications")       // [fresh|stale|duplicate]count
+	commandsMetric               = expvar.NewMap("commands")                   // [method:httpStatus]count
+	deviceRequestsMetric         = expvar.NewMap("deviceRequests")             // [completed|timeout|offline]count
+	deviceStatusMetric           = expvar.NewMap("deviceStatus")               // [online|offline]count of updates
+	commandQueueMetric           = expvar.NewMap("commandQueue")               // [queued|delivered|expired|rejected]count
+	spoolMetric                  = expvar.NewMap("resourceAggregateSpool")     // [spooled|collapsed|replayed|dropped|rejected]count
+	spoolSizeMetric              = expvar.NewInt("resourceAggregateSpoolSize") // count of spooled commands
+	shadowMetric                 = expvar.NewMap("shadow")                     // [hit|miss|bypass]count of retrieve commands
+	subscriptionsMetric          = expvar.NewMap("subscriptions")              // [subscribed|unsubscribed|delivered|failed]count
+	twinMetric                   = expvar.NewMap("desiredState")               // [pending|converged|conflict|failed]count of transitions
+	autoDiscoveryMetric          = expvar.NewMap("autoDiscovery")              // [succeeded|skipped|failed]count
+	keepaliveMetric              = expvar.NewMap("keepalive")                  // [sent|skipped|timeout|terminated]count
+	pingRTTMetric                = expvar.NewMap("pingRTT")                    // [<10ms|<100ms|<1s|>=1s]count of keepalive pings
+	droppedNotificationsMetric   = expvar.NewInt("droppedNotifications")       // count of notifications dropped by full notification queues
+)
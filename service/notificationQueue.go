@@ -0,0 +1,73 @@
+package service
+
+import (
+	"sync"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+)
+
+//notificationQueue forwards notifications of one resource in the order they were received.
+//When the queue is full the oldest notification is dropped, because it is superseded by newer ones.
+type notificationQueue struct {
+	name    string
+	queue   chan coap.Message
+	forward func(msg coap.Message)
+
+	pushLock sync.Mutex
+	closed   bool
+	done     chan struct{}
+}
+
+func newNotificationQueue(name string, size int, forward func(msg coap.Message)) *notificationQueue {
+	if size <= 0 {
+		size = 1
+	}
+	q := &notificationQueue{
+		name:    name,
+		queue:   make(chan coap.Message, size),
+		forward: forward,
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+func (q *notificationQueue) run() {
+	defer close(q.done)
+	for msg := range q.queue {
+		q.forward(msg)
+	}
+}
+
+func (q *notificationQueue) push(msg coap.Message) {
+	q.pushLock.Lock()
+	defer q.pushLock.Unlock()
+	if q.closed {
+		return
+	}
+	for {
+		select {
+		case q.queue <- msg:
+			return
+		default:
+		}
+		select {
+		case <-q.queue:
+			droppedNotificationsMetric.Add(1)
+			log.Warnf("Notification queue of %v is full: the oldest notification was dropped", q.name)
+		default:
+		}
+	}
+}
+
+//close stops the queue, notifications which are already queued are still forwarded
+func (q *notificationQueue) close() {
+	q.pushLock.Lock()
+	defer q.pushLock.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	close(q.queue)
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"testing"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+type testMessage struct {
+	coap.Message
+	seq int
+}
+
+func TestNotificationQueue(t *testing.T) {
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var forwarded []int
+	q := newNotificationQueue("test", 2, func(msg coap.Message) {
+		if len(forwarded) == 0 {
+			started <- struct{}{}
+			<-release
+		}
+		forwarded = append(forwarded, msg.(testMessage).seq)
+	})
+
+	dropped := droppedNotificationsMetric.Value()
+	q.push(testMessage{seq: 1})
+	<-started
+	for i := 2; i <= 5; i++ {
+		q.push(testMessage{seq: i})
+	}
+	if d := droppedNotificationsMetric.Value() - dropped; d != 2 {
+		t.Fatalf("unexpected count %v of dropped notifications", d)
+	}
+	close(release)
+	q.close()
+	<-q.done
+	q.push(testMessage{seq: 6})
+
+	expected := []int{1, 4, 5}
+	if len(forwarded) != len(expected) {
+		t.Fatalf("unexpected forwarded notifications %v, expected %v", forwarded, expected)
+	}
+	for i := range expected {
+		if forwarded[i] != expected[i] {
+			t.Fatalf("unexpected forwarded notifications %v, expected %v", forwarded, expected)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+//notificationThrottle forwards at most one notification per pmin, notifications received within pmin are coalesced
+//so only the latest one is forwarded after the window. When nothing was forwarded for pmax the resource is refreshed.
+type notificationThrottle struct {
+	throttling notificationThrottling
+	forward    func(msg coap.Message)
+	refresh    func()
+
+	lastForwarded time.Time
+	pending       coap.Message
+	pminTimer     *time.Timer
+	pmaxTimer     *time.Timer
+	stopped       bool
+	mutex         sync.Mutex
+}
+
+func newNotificationThrottle(throttling notificationThrottling, forward func(msg coap.Message), refresh func()) *notificationThrottle {
+	t := &notificationThrottle{
+		throttling: throttling,
+		forward:    forward,
+		refresh:    refresh,
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.resetPmaxLocked()
+	return t
+}
+
+func (t *notificationThrottle) resetPmaxLocked() {
+	if t.throttling.pmax <= 0 || t.stopped {
+		return
+	}
+	if t.pmaxTimer == nil {
+		t.pmaxTimer = time.AfterFunc(t.throttling.pmax, t.onPmax)
+		return
+	}
+	t.pmaxTimer.Reset(t.throttling.pmax)
+}
+
+func (t *notificationThrottle) forwardLocked(msg coap.Message) {
+	t.lastForwarded = time.Now()
+	t.forward(msg)
+	t.resetPmaxLocked()
+}
+
+func (t *notificationThrottle) notify(msg coap.Message) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.stopped {
+		return
+	}
+	elapsed := time.Since(t.lastForwarded)
+	if t.pending == nil && elapsed >= t.throttling.pmin {
+		t.forwardLocked(msg)
+		return
+	}
+	if t.pending != nil {
+		throttledNotificationsMetric.Add(1)
+	}
+	t.pending = msg
+	if t.pminTimer == nil {
+		t.pminTimer = time.AfterFunc(t.throttling.pmin-elapsed, t.flush)
+	}
+}
+
+func (t *notificationThrottle) flush() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.pminTimer = nil
+	if t.stopped || t.pending == nil {
+		return
+	}
+	msg := t.pending
+	t.pending = nil
+	t.forwardLocked(msg)
+}
+
+func (t *notificationThrottle) onPmax() {
+	t.mutex.Lock()
+	stopped := t.stopped
+	t.mutex.Unlock()
+	if stopped {
+		return
+	}
+	t.refresh()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.resetPmaxLocked()
+}
+
+func (t *notificationThrottle) stop() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.stopped = true
+	if t.pminTimer != nil {
+		t.pminTimer.Stop()
+	}
+	if t.pmaxTimer != nil {
+		t.pmaxTimer.Stop()
+	}
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/ugorji/go/codec"
+)
+
+func TestNotificationThrottleCoalescing(t *testing.T) {
+	forwarded := make(chan int, 10)
+	throttle := newNotificationThrottle(notificationThrottling{pmin: 100 * time.Millisecond}, func(msg coap.Message) {
+		forwarded <- msg.(testMessage).seq
+	}, func() {})
+	defer throttle.stop()
+
+	for i := 1; i <= 3; i++ {
+		throttle.notify(testMessage{seq: i})
+	}
+	for _, expected := range []int{1, 3} {
+		select {
+		case seq := <-forwarded:
+			if seq != expected {
+				t.Fatalf("unexpected forwarded notification %v, expected %v", seq, expected)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("notification %v was not forwarded", expected)
+		}
+	}
+	select {
+	case seq := <-forwarded:
+		t.Fatalf("unexpected forwarded notification %v", seq)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestNotificationThrottleRefresh(t *testing.T) {
+	refreshed := make(chan struct{}, 10)
+	throttle := newNotificationThrottle(notificationThrottling{pmax: 50 * time.Millisecond}, func(msg coap.Message) {}, func() {
+		refreshed <- struct{}{}
+	})
+	select {
+	case <-refreshed:
+	case <-time.After(time.Second):
+		t.Fatalf("resource was not refreshed")
+	}
+	throttle.stop()
+}
+
+func TestThrottlingPolicy(t *testing.T) {
+	var p throttlingPolicy
+	if err := p.Decode("1s/0s,rt:oic.r.temperature=10s/1h,href:/light=0s/30s"); err != nil {
+		t.Fatalf("cannot decode policy: %v", err)
+	}
+	link := notificationThrottling{pmin: 2 * time.Second, pmax: time.Minute}
+	tbl := []struct {
+		name       string
+		res        resources.Resource
+		link       notificationThrottling
+		throttling notificationThrottling
+	}{
+		{"Default", resources.Resource{Href: "/a"}, notificationThrottling{}, notificationThrottling{pmin: time.Second}},
+		{"ResourceType", resources.Resource{Href: "/temp", ResourceTypes: []string{"oic.r.temperature"}}, notificationThrottling{}, notificationThrottling{pmin: 10 * time.Second, pmax: time.Hour}},
+		{"Href", resources.Resource{Href: "/light", ResourceTypes: []string{"oic.r.temperature"}}, notificationThrottling{}, notificationThrottling{pmax: 30 * time.Second}},
+		{"Link", resources.Resource{Href: "/light"}, link, link},
+	}
+	for _, test := range tbl {
+		tf := func(t *testing.T) {
+			if throttling := p.throttling(test.res, test.link); throttling != test.throttling {
+				t.Fatalf("unexpected throttling %v, expected %v", throttling, test.throttling)
+			}
+		}
+		t.Run(test.name, tf)
+	}
+
+	for _, invalid := range []string{"1s", "a/1s", "2s/1s", "rt:[=1s/0s", "unknown=1s/0s"} {
+		if err := p.Decode(invalid); err == nil {
+			t.Fatalf("policy %v must be invalid", invalid)
+		}
+	}
+}
+
+func TestParseLinkThrottling(t *testing.T) {
+	links := []wkRdLink{
+		{Resource: resources.Resource{DeviceId: "a", Href: "/a"}, Policies: &wkRdLinkPolicies{BitFlags: 2, Pmin: 1, Pmax: 60}},
+		{Resource: resources.Resource{DeviceId: "a", Href: "/b"}, Policies: &wkRdLinkPolicies{BitFlags: 2}},
+		{Resource: resources.Resource{DeviceId: "a", Href: "/c"}, Policies: &wkRdLinkPolicies{Pmin: 60, Pmax: 1}},
+		{Resource: resources.Resource{DeviceId: "a", Href: "/d"}},
+	}
+	throttling := parseLinkThrottling(links)
+	if len(throttling) != 1 || throttling["a/a"] != (notificationThrottling{pmin: time.Second, pmax: time.Minute}) {
+		t.Fatalf("unexpected throttling %v", throttling)
+	}
+	if res := links[0].resource(); res.Policies == nil || res.Policies.BitFlags != 2 {
+		t.Fatalf("unexpected policies %v of resource", res.Policies)
+	}
+	if res := links[3].resource(); res.Policies != nil {
+		t.Fatalf("unexpected policies %v of resource without policy", res.Policies)
+	}
+}
+
+func TestDecodePublishLinkPolicies(t *testing.T) {
+	data, err := json2cbor(`{"di":"a","links":[{"di":"a","href":"/a","p":{"bm":2,"pmin":1,"pmax":60}}],"ttl":12345}`)
+	if err != nil {
+		t.Fatalf("cannot encode payload: %v", err)
+	}
+	var w wkRdPublish
+	if err := codec.NewDecoderBytes(data, new(codec.CborHandle)).Decode(&w); err != nil {
+		t.Fatalf("cannot decode payload: %v", err)
+	}
+	if len(w.Links) != 1 {
+		t.Fatalf("unexpected links %+v", w.Links)
+	}
+	if res := w.Links[0].resource(); res.Policies == nil || res.Policies.BitFlags != 2 {
+		t.Fatalf("unexpected policies %v of resource", res.Policies)
+	}
+	if throttling := parseLinkThrottling(w.Links); throttling["a/a"] != (notificationThrottling{pmin: time.Second, pmax: time.Minute}) {
+		t.Fatalf("unexpected throttling %v", throttling)
+	}
+}
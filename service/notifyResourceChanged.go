@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/go-ocf/resources/uri"
+	"github.com/valyala/fasthttp"
+)
+
+func postResourceNotifyChangedURI(server *Server) string {
+	return server.ResourceProtocol + "://" + server.ResourceHost + uri.NotifyResourceChanged
+}
+
+func coapContentFormat2ContentType(contentFormat int32) string {
+	switch coap.MediaType(contentFormat) {
+	case coap.TextPlain:
+		return "text/plain"
+	case coap.AppLinkFormat:
+		return "application/link-format"
+	case coap.AppXML:
+		return "application/xml"
+	case coap.AppOctets:
+		return "application/octet-stream"
+	case coap.AppExi:
+		return "application/exi"
+	case coap.AppJSON:
+		return "application/json"
+	case coap.AppCBOR:
+		return "application/cbor"
+	case coap.AppOcfCbor:
+		return "application/vnd.ocf+cbor"
+	}
+	return ""
+}
+
+//coapMsg2Content converts payload of message to content of resource, content format is -1 when message doesn't contains it
+func coapMsg2Content(msg coap.Message) *resources.Content {
+	contentFormat := int32(-1)
+	if mt, ok := msg.Option(coap.ContentFormat).(coap.MediaType); ok {
+		contentFormat = int32(mt)
+	}
+	return &resources.Content{
+		Data:              msg.Payload(),
+		ContentType:       coapContentFormat2ContentType(contentFormat),
+		CoapContentFormat: contentFormat,
+	}
+}
+
+//notifyResourceChanged sends content of the resource to resource aggregate
+func notifyResourceChanged(server *Server, authContext commands.AuthorizationContext, res resources.Resource, content *resources.Content) error {
+	request := commands.NotifyResourceChangedRequest{
+		AuthorizationContext: &authContext,
+		ResourceId:           res.Id,
+		Content:              content,
+	}
+
+	httpCode, spooled, err := sendResourceAggregateCommand(server, notifyResourceChangedCommand, res.Id, &request)
+	if spooled {
+		log.Debugf("change of resource ocf://%v%v was spooled", res.DeviceId, res.Href)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("cannot notify resource aggregate about change of resource ocf://%v%v: %v", res.DeviceId, res.Href, err)
+	}
+	if httpCode != fasthttp.StatusOK {
+		return fmt.Errorf("cannot notify resource aggregate about change of resource ocf://%v%v: unexpected status code %v", res.DeviceId, res.Href, httpCode)
+	}
+	log.Debugf("change of resource ocf://%v%v was notified", res.DeviceId, res.Href)
+	return nil
+}
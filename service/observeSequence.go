@@ -0,0 +1,50 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	observeSequenceHalfRange = 1 << 23
+	observeSequenceMask      = 1<<24 - 1
+	observeSequenceMaxAge    = 128 * time.Second
+)
+
+//isObserveSequenceFresh decides whether notification with sequence v2 received at t2 is newer than
+//notification with sequence v1 received at t1 by rules of https://tools.ietf.org/html/rfc7641#section-3.4
+func isObserveSequenceFresh(v1 uint32, t1 time.Time, v2 uint32, t2 time.Time) bool {
+	v1 &= observeSequenceMask
+	v2 &= observeSequenceMask
+	return (v1 < v2 && v2-v1 < observeSequenceHalfRange) ||
+		(v1 > v2 && v1-v2 > observeSequenceHalfRange) ||
+		t2.After(t1.Add(observeSequenceMaxAge))
+}
+
+//observeSequence tracks the observe sequence number of the latest notification of an observation
+type observeSequence struct {
+	valid    bool
+	value    uint32
+	received time.Time
+	mutex    sync.Mutex
+}
+
+//check returns true and remembers the sequence when notification is fresh, duplicate is true when the same sequence was already received
+func (s *observeSequence) check(value uint32, received time.Time) (fresh bool, duplicate bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.valid && !isObserveSequenceFresh(s.value, s.received, value, received) {
+		return false, s.value&observeSequenceMask == value&observeSequenceMask
+	}
+	s.valid = true
+	s.value = value
+	s.received = received
+	return true, false
+}
+
+//reset forgets the sequence, it is called when a new observation is established
+func (s *observeSequence) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.valid = false
+}
@@ -0,0 +1,50 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsObserveSequenceFresh(t *testing.T) {
+	now := time.Now()
+	tbl := []struct {
+		name  string
+		v1    uint32
+		v2    uint32
+		t2    time.Time
+		fresh bool
+	}{
+		{"Newer", 1, 2, now, true},
+		{"Duplicate", 2, 2, now, false},
+		{"Older", 2, 1, now, false},
+		{"Wrapped", observeSequenceMask, 0, now, true},
+		{"OlderWrapped", 0, observeSequenceMask, now, false},
+		{"TooFarAhead", 0, observeSequenceHalfRange + 1, now, false},
+		{"OlderAfterMaxAge", 2, 1, now.Add(observeSequenceMaxAge + time.Second), true},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			if fresh := isObserveSequenceFresh(tt.v1, now, tt.v2, tt.t2); fresh != tt.fresh {
+				t.Fatalf("unexpected freshness %v of %v after %v", fresh, tt.v2, tt.v1)
+			}
+		})
+	}
+}
+
+func TestObserveSequence(t *testing.T) {
+	var s observeSequence
+	now := time.Now()
+	if fresh, _ := s.check(5, now); !fresh {
+		t.Fatalf("first notification must be fresh")
+	}
+	if fresh, duplicate := s.check(5, now); fresh || !duplicate {
+		t.Fatalf("unexpected fresh %v duplicate %v of repeated notification", fresh, duplicate)
+	}
+	if fresh, duplicate := s.check(4, now); fresh || duplicate {
+		t.Fatalf("unexpected fresh %v duplicate %v of stale notification", fresh, duplicate)
+	}
+	s.reset()
+	if fresh, _ := s.check(1, now); !fresh {
+		t.Fatalf("notification of new observation must be fresh")
+	}
+}
@@ -1,7 +1,15 @@
 package service
 
-import coap "github.com/go-ocf/go-coap"
+import (
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources"
+)
 
-func onGetResponse(req *coap.Request) {
+func onGetResponse(res resources.Resource, notifications *notificationQueue, req *coap.Request) {
 	decodeMsgToDebug(req.Msg, "onGetResponse")
+	if req.Msg.Code() != coap.Content {
+		reportDeviceErrorResponse(res, "get", req.Msg.Code())
+		return
+	}
+	notifications.push(req.Msg)
 }
@@ -1,7 +1,35 @@
 package service
 
-import coap "github.com/go-ocf/go-coap"
+import (
+	"fmt"
+	"time"
 
-func onObserveNotification(req *coap.Request) {
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+)
+
+func onObserveNotification(observer *resourceObserver, req *coap.Request) {
 	decodeMsgToDebug(req.Msg, "onObserveNotification")
+	if req.Msg.Code() != coap.Content {
+		reportDeviceErrorResponse(observer.res, "observe", req.Msg.Code())
+		observer.reobserve(fmt.Sprintf("device ended observation with code %v", req.Msg.Code()))
+		return
+	}
+	seq, ok := req.Msg.Option(coap.Observe).(uint32)
+	if !ok {
+		observer.received(req.Msg)
+		observer.reobserve("device ended observation by response without observe option")
+		return
+	}
+	if fresh, duplicate := observer.sequence.check(seq, time.Now()); !fresh {
+		if duplicate {
+			observeNotificationsMetric.Add("duplicate", 1)
+		} else {
+			observeNotificationsMetric.Add("stale", 1)
+		}
+		log.Debugf("Notification %v of ocf://%v%v is not fresh, duplicate: %v", seq, observer.res.DeviceId, observer.res.Href, duplicate)
+		return
+	}
+	observeNotificationsMetric.Add("fresh", 1)
+	observer.received(req.Msg)
 }
@@ -0,0 +1,81 @@
+package service
+
+import (
+	"bytes"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/ugorji/go/codec"
+)
+
+const pingURI = "/oic/ping"
+
+//pingConfiguration intervals in minutes which the device can choose from
+type pingConfiguration struct {
+	IntervalArray []int `json:"inarray"`
+}
+
+//pingRequest keepalive of the device with interval in minutes until the next one
+type pingRequest struct {
+	Interval int `json:"in"`
+}
+
+// https://openconnectivity.org/specs/OCF_Device_To_Cloud_Services_Specification_v2.0.pdf 5.5.4
+func pingGetHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	out := bytes.NewBuffer(make([]byte, 0, 64))
+	if err := codec.NewEncoder(out, new(codec.CborHandle)).Encode(pingConfiguration{IntervalArray: server.pingIntervals}); err != nil {
+		log.Errorf("Cannot marshal ping configuration for client %v: %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+	sendResponse(s, req.Client, coap.Content, out.Bytes())
+}
+
+func pingPostHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	session := server.clientContainer.find(req.Client.RemoteAddr().String())
+	if session == nil {
+		log.Errorf("Cannot find session for client %v", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.BadRequest, nil)
+		return
+	}
+	if session.loadAuthorizationContext().DeviceId == "" {
+		log.Errorf("Cannot handle ping of client %v: not signed in", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Unauthorized, nil)
+		return
+	}
+	var ping pingRequest
+	if err := codec.NewDecoderBytes(req.Msg.Payload(), new(codec.CborHandle)).Decode(&ping); err != nil {
+		log.Errorf("Cannot unmarshal ping of client %v: %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.BadRequest, nil)
+		return
+	}
+	if !containsInt(server.pingIntervals, ping.Interval) {
+		log.Errorf("Invalid ping interval %v of client %v", ping.Interval, req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.BadRequest, nil)
+		return
+	}
+	session.keepalive.devicePing(time.Duration(ping.Interval) * time.Minute)
+	sendResponse(s, req.Client, coap.Changed, nil)
+}
+
+func containsInt(values []int, value int) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func pingHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	switch req.Msg.Code() {
+	case coap.GET:
+		pingGetHandler(s, req, server)
+	case coap.POST:
+		pingPostHandler(s, req, server)
+	default:
+		log.Errorf("Forbidden request from %v", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Forbidden, nil)
+	}
+}
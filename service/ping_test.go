@@ -0,0 +1,45 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestKeepaliveMissedDevicePings(t *testing.T) {
+	closed := 0
+	k := testKeepalive(newKeepaliveScheduler(0, 1), func(time.Duration) error { return nil }, &closed)
+	now := time.Now()
+	if misses := k.missedDevicePings(now.Add(time.Hour)); misses != 0 {
+		t.Fatalf("device without /oic/ping missed %v pings", misses)
+	}
+	k.devicePing(time.Minute)
+	if k.idleTime() != time.Minute {
+		t.Fatalf("unexpected idle time %v", k.idleTime())
+	}
+	if misses := k.missedDevicePings(time.Now().Add(30 * time.Second)); misses != 0 {
+		t.Fatalf("unexpected misses %v", misses)
+	}
+	if misses := k.missedDevicePings(time.Now().Add(3*time.Minute + time.Second)); misses != 3 {
+		t.Fatalf("unexpected misses %v", misses)
+	}
+}
+
+func TestKeepaliveDevicePingRestartsIdleTimer(t *testing.T) {
+	s := newKeepaliveScheduler(0, 1)
+	closed := 0
+	k := testKeepalive(s, func(time.Duration) error { return nil }, &closed)
+	s.schedule(k, 0)
+	k.devicePing(time.Minute)
+	if due, _ := s.popDue(time.Now().Add(30 * time.Second)); len(due) != 0 {
+		t.Fatalf("keepalive is due before the idle time")
+	}
+	if due, _ := s.popDue(time.Now().Add(2 * time.Minute)); len(due) != 1 {
+		t.Fatalf("unexpected due keepalives %v", due)
+	}
+}
+
+func TestContainsInt(t *testing.T) {
+	if !containsInt([]int{1, 2, 4, 8}, 4) || containsInt([]int{1, 2, 4, 8}, 3) {
+		t.Fatalf("unexpected result")
+	}
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+type pollingRule struct {
+	resourceRule
+	interval time.Duration
+}
+
+//pollingPolicy decides how often non-observable resources are retrieved from devices.
+//It is configured by comma separated rules, eg.: "5m,rt:oic.r.temperature=30s,href:/light/*=1m".
+//A bare duration sets the default, href rules take precedence over rt rules
+//and zero interval disables polling, so the resource is retrieved only when it is published.
+type pollingPolicy struct {
+	defaultInterval time.Duration
+	rules           []pollingRule
+}
+
+func parsePollingInterval(value string) (time.Duration, error) {
+	i, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid polling interval %v: %v", value, err)
+	}
+	if i < 0 {
+		return 0, fmt.Errorf("Invalid polling interval %v", value)
+	}
+	return i, nil
+}
+
+//Decode parses pollingPolicy from env variable
+func (p *pollingPolicy) Decode(value string) error {
+	var policy pollingPolicy
+	keys, values := splitPolicyRules(value)
+	for i := range keys {
+		interval, err := parsePollingInterval(values[i])
+		if err != nil {
+			return err
+		}
+		if keys[i] == "" {
+			policy.defaultInterval = interval
+			continue
+		}
+		r, err := parseResourceRule(keys[i])
+		if err != nil {
+			return err
+		}
+		policy.rules = append(policy.rules, pollingRule{resourceRule: r, interval: interval})
+	}
+	*p = policy
+	return nil
+}
+
+//interval returns polling interval of the resource
+func (p *pollingPolicy) interval(res resources.Resource) time.Duration {
+	for _, href := range []bool{true, false} {
+		for _, r := range p.rules {
+			if r.href == href && r.match(res) {
+				return r.interval
+			}
+		}
+	}
+	return p.defaultInterval
+}
@@ -0,0 +1,39 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+func TestPollingPolicy(t *testing.T) {
+	var p pollingPolicy
+	if err := p.Decode("5m,rt:oic.r.temperature=30s,href:/light/*=1m,href:/static=0s"); err != nil {
+		t.Fatalf("cannot decode policy: %v", err)
+	}
+	tbl := []struct {
+		name     string
+		res      resources.Resource
+		interval time.Duration
+	}{
+		{"Default", resources.Resource{Href: "/a"}, 5 * time.Minute},
+		{"ResourceType", resources.Resource{Href: "/temp", ResourceTypes: []string{"oic.r.sensor", "oic.r.temperature"}}, 30 * time.Second},
+		{"Href", resources.Resource{Href: "/light/1", ResourceTypes: []string{"oic.r.temperature"}}, time.Minute},
+		{"Disabled", resources.Resource{Href: "/static"}, 0},
+	}
+	for _, test := range tbl {
+		tf := func(t *testing.T) {
+			if interval := p.interval(test.res); interval != test.interval {
+				t.Fatalf("unexpected interval %v, expected %v", interval, test.interval)
+			}
+		}
+		t.Run(test.name, tf)
+	}
+
+	for _, invalid := range []string{"a", "-1s", "rt:[=1s", "unknown=1s", "href:/a=b"} {
+		if err := p.Decode(invalid); err == nil {
+			t.Fatalf("policy %v must be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	rdSelectionReasonDefault      = "default"
+	rdSelectionReasonDevice       = "device"
+	rdSelectionReasonUser         = "user"
+	rdSelectionReasonUpstreamOpen = "upstreamOpen"
+)
+
+type rdSelectionRule struct {
+	pattern           string
+	selectionCriteria int
+}
+
+//rdSelectionPolicy decides selection criteria of resource directory for a device.
+//It is configured by comma separated rules, eg.: "0,device:abc*=1,user:42=1,upstreamOpen=1".
+//A bare number sets the default, device and user rules match IDs by path.Match pattern
+//and upstreamOpen is used when circuit of resource aggregate is open.
+type rdSelectionPolicy struct {
+	defaultCriteria      int
+	deviceRules          []rdSelectionRule
+	userRules            []rdSelectionRule
+	upstreamOpenCriteria *int
+}
+
+func newRDSelectionRule(pattern string, sel int) (rdSelectionRule, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return rdSelectionRule{}, fmt.Errorf("Invalid pattern %v: %v", pattern, err)
+	}
+	return rdSelectionRule{pattern: pattern, selectionCriteria: sel}, nil
+}
+
+func parseSelectionCriteria(value string) (int, error) {
+	sel, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid selection criteria %v: %v", value, err)
+	}
+	if sel < 0 {
+		return 0, fmt.Errorf("Invalid selection criteria %v", value)
+	}
+	return sel, nil
+}
+
+//Decode parses rdSelectionPolicy from env variable
+func (p *rdSelectionPolicy) Decode(value string) error {
+	var policy rdSelectionPolicy
+	keys, values := splitPolicyRules(value)
+	for i, key := range keys {
+		sel, err := parseSelectionCriteria(values[i])
+		if err != nil {
+			return err
+		}
+		switch {
+		case key == "":
+			policy.defaultCriteria = sel
+		case key == rdSelectionReasonUpstreamOpen:
+			policy.upstreamOpenCriteria = &sel
+		case strings.HasPrefix(key, "device:"):
+			r, err := newRDSelectionRule(strings.TrimPrefix(key, "device:"), sel)
+			if err != nil {
+				return err
+			}
+			policy.deviceRules = append(policy.deviceRules, r)
+		case strings.HasPrefix(key, "user:"):
+			r, err := newRDSelectionRule(strings.TrimPrefix(key, "user:"), sel)
+			if err != nil {
+				return err
+			}
+			policy.userRules = append(policy.userRules, r)
+		default:
+			return fmt.Errorf("Unsupported rule %v of resource directory selection policy", key)
+		}
+	}
+	*p = policy
+	return nil
+}
+
+func matchRDSelectionRules(rules []rdSelectionRule, id string) (int, bool) {
+	if id == "" {
+		return 0, false
+	}
+	for _, r := range rules {
+		if ok, _ := path.Match(r.pattern, id); ok {
+			return r.selectionCriteria, true
+		}
+	}
+	return 0, false
+}
+
+//selectionCriteria returns selection criteria for the device and the reason of the decision
+func (p *rdSelectionPolicy) selectionCriteria(deviceID, userID string, upstreamOpen bool) (int, string) {
+	if upstreamOpen && p.upstreamOpenCriteria != nil {
+		return *p.upstreamOpenCriteria, rdSelectionReasonUpstreamOpen
+	}
+	if sel, ok := matchRDSelectionRules(p.deviceRules, deviceID); ok {
+		return sel, rdSelectionReasonDevice
+	}
+	if sel, ok := matchRDSelectionRules(p.userRules, userID); ok {
+		return sel, rdSelectionReasonUser
+	}
+	return p.defaultCriteria, rdSelectionReasonDefault
+}
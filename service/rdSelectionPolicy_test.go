@@ -0,0 +1,39 @@
+package service
+
+import "testing"
+
+func TestRDSelectionPolicy(t *testing.T) {
+	var p rdSelectionPolicy
+	if err := p.Decode("1,device:bridge-*=2,user:u1=3,upstreamOpen=4"); err != nil {
+		t.Fatalf("cannot decode policy: %v", err)
+	}
+	tbl := []struct {
+		name         string
+		deviceID     string
+		userID       string
+		upstreamOpen bool
+		sel          int
+		reason       string
+	}{
+		{"Default", "a", "u0", false, 1, rdSelectionReasonDefault},
+		{"Device", "bridge-1", "u1", false, 2, rdSelectionReasonDevice},
+		{"User", "a", "u1", false, 3, rdSelectionReasonUser},
+		{"UpstreamOpen", "bridge-1", "u1", true, 4, rdSelectionReasonUpstreamOpen},
+		{"NotSignedIn", "", "", false, 1, rdSelectionReasonDefault},
+	}
+	for _, test := range tbl {
+		tf := func(t *testing.T) {
+			sel, reason := p.selectionCriteria(test.deviceID, test.userID, test.upstreamOpen)
+			if sel != test.sel || reason != test.reason {
+				t.Fatalf("unexpected selection %v (%v), expected %v (%v)", sel, reason, test.sel, test.reason)
+			}
+		}
+		t.Run(test.name, tf)
+	}
+
+	for _, invalid := range []string{"a", "-1", "device:[=1", "unknown=1", "user:x=y"} {
+		if err := p.Decode(invalid); err == nil {
+			t.Fatalf("policy %v must be invalid", invalid)
+		}
+	}
+}
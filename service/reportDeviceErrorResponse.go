@@ -0,0 +1,15 @@
+package service
+
+import (
+	"fmt"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//reportDeviceErrorResponse reports response of device with error status, the content of such response is not forwarded to resource aggregate
+func reportDeviceErrorResponse(res resources.Resource, operation string, code coap.COAPCode) {
+	log.Errorf("Device responded to %v of ocf://%v%v with code %v", operation, res.DeviceId, res.Href, code)
+	deviceErrorResponsesMetric.Add(fmt.Sprintf("%v:%v", operation, code), 1)
+}
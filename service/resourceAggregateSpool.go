@@ -0,0 +1,379 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-ocf/kit/http"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+	"github.com/valyala/fasthttp"
+)
+
+type resourceAggregateCommand string
+
+const (
+	publishResourceCommand       resourceAggregateCommand = "publish"
+	unpublishResourceCommand     resourceAggregateCommand = "unpublish"
+	notifyResourceChangedCommand resourceAggregateCommand = "notify"
+)
+
+type protobufRequest interface {
+	Marshal() ([]byte, error)
+	Unmarshal([]byte) error
+}
+
+//spooledCommand command for resource aggregate waiting for its recovery
+type spooledCommand struct {
+	Seq        uint64                   `json:"seq"`
+	Revision   int                      `json:"rev"` // incremented when a newer change of the resource replaced the request
+	Command    resourceAggregateCommand `json:"command"`
+	ResourceID string                   `json:"resourceId"`
+	Request    []byte                   `json:"request"` // protobuf encoded request without access token
+
+	accessToken string // access token of the request is kept in memory only
+}
+
+//spoolRecord change of the spool in its journal
+type spoolRecord struct {
+	Spooled *spooledCommand `json:"spooled,omitempty"`
+	Removed uint64          `json:"removed,omitempty"`
+}
+
+//resourceAggregateSpool keeps commands for resource aggregate while it is unavailable and replays them in order per resource.
+//Spooled change of a resource is replaced by the newer change when no other command of the resource follows it.
+type resourceAggregateSpool struct {
+	journal    *journal
+	maxEntries int // zero disables the spool
+	maxBytes   int // maximal size of spooled requests, zero means unlimited
+
+	entries map[uint64]*spooledCommand // [seq]
+	order   []uint64                   // seq of spooled commands in order, removed ones are skipped
+	last    map[string]uint64          // [resourceID] seq of the last spooled command of the resource
+	counts  map[string]int             // [resourceID] number of spooled commands of the resource
+	bytes   int
+	seq     uint64
+	mutex   sync.Mutex
+}
+
+func newResourceAggregateSpool(file string, maxEntries, maxBytes int) (*resourceAggregateSpool, error) {
+	s := &resourceAggregateSpool{
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		entries:    make(map[uint64]*spooledCommand),
+		last:       make(map[string]uint64),
+		counts:     make(map[string]int),
+	}
+	var err error
+	s.journal, err = openJournal(file, func(data []byte) error {
+		var record spoolRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		if record.Spooled != nil {
+			s.putLocked(record.Spooled)
+		}
+		if record.Removed != 0 {
+			s.removeLocked(record.Removed)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	spoolSizeMetric.Set(int64(len(s.entries)))
+	return s, nil
+}
+
+func (s *resourceAggregateSpool) enabled() bool {
+	return s.maxEntries > 0
+}
+
+//pending returns true when commands of the resource are spooled, newer commands of the resource must follow them
+func (s *resourceAggregateSpool) pending(resourceID string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.counts[resourceID] > 0
+}
+
+//putLocked adds the command or replaces the spooled command with the same seq
+func (s *resourceAggregateSpool) putLocked(c *spooledCommand) {
+	if old, ok := s.entries[c.Seq]; ok {
+		s.bytes -= len(old.Request)
+		s.entries[c.Seq] = c
+		s.bytes += len(c.Request)
+		return
+	}
+	s.entries[c.Seq] = c
+	s.order = append(s.order, c.Seq)
+	s.last[c.ResourceID] = c.Seq
+	s.counts[c.ResourceID]++
+	s.bytes += len(c.Request)
+	if c.Seq > s.seq {
+		s.seq = c.Seq
+	}
+}
+
+func (s *resourceAggregateSpool) removeLocked(seq uint64) bool {
+	c, ok := s.entries[seq]
+	if !ok {
+		return false
+	}
+	delete(s.entries, seq)
+	s.bytes -= len(c.Request)
+	s.counts[c.ResourceID]--
+	if s.counts[c.ResourceID] <= 0 {
+		delete(s.counts, c.ResourceID)
+		delete(s.last, c.ResourceID)
+	}
+	for len(s.order) > 0 {
+		if _, ok := s.entries[s.order[0]]; ok {
+			break
+		}
+		s.order = s.order[1:]
+	}
+	if len(s.order) > 2*len(s.entries)+64 {
+		order := make([]uint64, 0, len(s.entries))
+		for _, seq := range s.order {
+			if _, ok := s.entries[seq]; ok {
+				order = append(order, seq)
+			}
+		}
+		s.order = order
+	}
+	return true
+}
+
+func (s *resourceAggregateSpool) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(s.entries))
+	for _, seq := range s.order {
+		if c, ok := s.entries[seq]; ok {
+			records = append(records, spoolRecord{Spooled: c})
+		}
+	}
+	return records
+}
+
+func (s *resourceAggregateSpool) add(command resourceAggregateCommand, resourceID string, request []byte, accessToken string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	defer spoolSizeMetric.Set(int64(len(s.entries)))
+
+	if command == notifyResourceChangedCommand {
+		if last, ok := s.entries[s.last[resourceID]]; ok && last.Command == notifyResourceChangedCommand {
+			c := *last
+			c.Revision++
+			c.Request = request
+			c.accessToken = accessToken
+			s.putLocked(&c)
+			s.journal.write(spoolRecord{Spooled: &c}, s.snapshotLocked)
+			spoolMetric.Add("collapsed", 1)
+			s.dropOverLimitLocked()
+			return
+		}
+	}
+	s.seq++
+	c := &spooledCommand{Seq: s.seq, Command: command, ResourceID: resourceID, Request: request, accessToken: accessToken}
+	s.putLocked(c)
+	s.journal.write(spoolRecord{Spooled: c}, s.snapshotLocked)
+	spoolMetric.Add("spooled", 1)
+	s.dropOverLimitLocked()
+}
+
+func (s *resourceAggregateSpool) dropOverLimitLocked() {
+	for len(s.order) > 0 && (len(s.entries) > s.maxEntries || (s.maxBytes > 0 && s.bytes > s.maxBytes)) {
+		c := s.entries[s.order[0]]
+		log.Errorf("Spool of resource aggregate is full, dropping %v of resource %v", c.Command, c.ResourceID)
+		s.removeLocked(c.Seq)
+		s.journal.write(spoolRecord{Removed: c.Seq}, s.snapshotLocked)
+		spoolMetric.Add("dropped", 1)
+	}
+}
+
+//list returns spooled commands in order
+func (s *resourceAggregateSpool) list() []spooledCommand {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	commands := make([]spooledCommand, 0, len(s.entries))
+	for _, seq := range s.order {
+		if c, ok := s.entries[seq]; ok {
+			commands = append(commands, *c)
+		}
+	}
+	return commands
+}
+
+//remove removes the replayed command, it is kept when it was replaced by a newer change meanwhile
+func (s *resourceAggregateSpool) remove(c spooledCommand) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if spooled, ok := s.entries[c.Seq]; !ok || spooled.Revision != c.Revision {
+		return
+	}
+	s.removeLocked(c.Seq)
+	s.journal.write(spoolRecord{Removed: c.Seq}, s.snapshotLocked)
+	spoolSizeMetric.Set(int64(len(s.entries)))
+}
+
+func resourceAggregateCommandURI(server *Server, command resourceAggregateCommand) string {
+	switch command {
+	case publishResourceCommand:
+		return postResourcePublishURI(server)
+	case unpublishResourceCommand:
+		return postResourceUnpublishURI(server)
+	}
+	return postResourceNotifyChangedURI(server)
+}
+
+func postResourceAggregateCommand(server *Server, command resourceAggregateCommand, request protobufRequest) (int, error) {
+	httpRequestCtx := http.AcquireRequestCtx()
+	defer http.ReleaseRequestCtx(httpRequestCtx)
+
+	var response protobufRequest
+	switch command {
+	case publishResourceCommand:
+		response = &commands.PublishResourceResponse{}
+	case unpublishResourceCommand:
+		response = &commands.UnpublishResourceResponse{}
+	default:
+		response = &commands.NotifyResourceChangedResponse{}
+	}
+	httpCode, err := httpRequestCtx.PostProto(server.httpClient, resourceAggregateCommandURI(server, command), request, response)
+	server.resourceCircuit.record(httpCode, err)
+	return httpCode, err
+}
+
+//authorizationContextOf returns authorization context of the command for resource aggregate
+func authorizationContextOf(request protobufRequest) *commands.AuthorizationContext {
+	switch r := request.(type) {
+	case *commands.PublishResourceRequest:
+		return r.AuthorizationContext
+	case *commands.UnpublishResourceRequest:
+		return r.AuthorizationContext
+	case *commands.NotifyResourceChangedRequest:
+		return r.AuthorizationContext
+	}
+	return nil
+}
+
+//marshalWithoutAccessToken encodes the request for the spool, the access token is returned separately so it never reaches the disk
+func marshalWithoutAccessToken(request protobufRequest) ([]byte, string, error) {
+	authContext := authorizationContextOf(request)
+	if authContext == nil {
+		data, err := request.Marshal()
+		return data, "", err
+	}
+	accessToken := authContext.AccessToken
+	authContext.AccessToken = ""
+	data, err := request.Marshal()
+	authContext.AccessToken = accessToken
+	return data, accessToken, err
+}
+
+func isResourceAggregateUnavailable(httpCode int, err error) bool {
+	return err != nil || httpCode >= fasthttp.StatusInternalServerError
+}
+
+//sendResourceAggregateCommand sends command to resource aggregate. The command is spooled, and spooled is true,
+//when resource aggregate is unavailable or when older commands of the resource are spooled.
+func sendResourceAggregateCommand(server *Server, command resourceAggregateCommand, resourceID string, request protobufRequest) (httpCode int, spooled bool, err error) {
+	if !server.resourceSpool.enabled() {
+		httpCode, err = postResourceAggregateCommand(server, command, request)
+		return httpCode, false, err
+	}
+	if !server.resourceCircuit.isOpen() && !server.resourceSpool.pending(resourceID) {
+		httpCode, err = postResourceAggregateCommand(server, command, request)
+		if !isResourceAggregateUnavailable(httpCode, err) {
+			return httpCode, false, err
+		}
+		log.Errorf("Resource aggregate is unavailable, %v of resource %v is spooled: %v, status code %v", command, resourceID, err, httpCode)
+	}
+	data, accessToken, err := marshalWithoutAccessToken(request)
+	if err != nil {
+		return 0, false, fmt.Errorf("cannot spool %v of resource %v: %v", command, resourceID, err)
+	}
+	server.resourceSpool.add(command, resourceID, data, accessToken)
+	return 0, true, nil
+}
+
+func decodeSpooledCommand(c spooledCommand) (protobufRequest, error) {
+	var request protobufRequest
+	switch c.Command {
+	case publishResourceCommand:
+		request = &commands.PublishResourceRequest{}
+	case unpublishResourceCommand:
+		request = &commands.UnpublishResourceRequest{}
+	default:
+		request = &commands.NotifyResourceChangedRequest{}
+	}
+	if err := request.Unmarshal(c.Request); err != nil {
+		return nil, err
+	}
+	return request, nil
+}
+
+//spooledAccessToken returns access token of the spooled command, commands spooled before restart of the gateway
+//get the token of the signed-in session of their device
+func (server *Server) spooledAccessToken(c spooledCommand, authContext *commands.AuthorizationContext) string {
+	if c.accessToken != "" {
+		return c.accessToken
+	}
+	session := server.clientContainer.findByDeviceID(authContext.GetDeviceId())
+	if session == nil {
+		return ""
+	}
+	signedIn := session.loadAuthorizationContext()
+	if signedIn.DeviceId != authContext.GetDeviceId() {
+		return ""
+	}
+	return signedIn.AccessToken
+}
+
+//replaySpool sends spooled commands in order until resource aggregate fails again,
+//a resource is skipped until its device signs in when access token of its command is unknown
+func (server *Server) replaySpool() {
+	blocked := make(map[string]bool)
+	for _, c := range server.resourceSpool.list() {
+		if server.resourceCircuit.isOpen() {
+			return
+		}
+		if blocked[c.ResourceID] {
+			continue
+		}
+		request, err := decodeSpooledCommand(c)
+		if err != nil {
+			log.Errorf("Cannot decode spooled %v of resource %v: %v", c.Command, c.ResourceID, err)
+			server.resourceSpool.remove(c)
+			spoolMetric.Add("rejected", 1)
+			continue
+		}
+		if authContext := authorizationContextOf(request); authContext != nil {
+			authContext.AccessToken = server.spooledAccessToken(c, authContext)
+			if authContext.AccessToken == "" {
+				blocked[c.ResourceID] = true
+				continue
+			}
+		}
+		httpCode, err := postResourceAggregateCommand(server, c.Command, request)
+		if isResourceAggregateUnavailable(httpCode, err) {
+			log.Errorf("Cannot replay %v of resource %v: %v, status code %v", c.Command, c.ResourceID, err, httpCode)
+			return
+		}
+		server.resourceSpool.remove(c)
+		if httpCode != fasthttp.StatusOK {
+			log.Errorf("Resource aggregate rejected spooled %v of resource %v: unexpected status code %v", c.Command, c.ResourceID, httpCode)
+			spoolMetric.Add("rejected", 1)
+			continue
+		}
+		spoolMetric.Add("replayed", 1)
+	}
+}
+
+//replaySpoolPeriodically replays spooled commands every interval
+func (server *Server) replaySpoolPeriodically(interval time.Duration) {
+	for range time.Tick(interval) {
+		server.replaySpool()
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-ocf/resources/protobuf/resources/commands"
+)
+
+func TestResourceAggregateSpool(t *testing.T) {
+	dir, remove := testDataDir(t)
+	defer remove()
+	file := filepath.Join(dir, "spool.jsonl")
+
+	s, err := newResourceAggregateSpool(file, 3, 0)
+	if err != nil {
+		t.Fatalf("cannot create spool: %v", err)
+	}
+	s.add(publishResourceCommand, "a", []byte("publish a"), "token")
+	s.add(notifyResourceChangedCommand, "a", []byte("a1"), "token")
+	s.add(notifyResourceChangedCommand, "b", []byte("b1"), "token")
+	s.add(notifyResourceChangedCommand, "a", []byte("a2"), "token")
+	if list := s.list(); len(list) != 3 || string(list[1].Request) != "a2" {
+		t.Fatalf("change of resource was not collapsed: %v", list)
+	}
+	if !s.pending("a") || s.pending("c") {
+		t.Fatalf("unexpected pending resources")
+	}
+
+	s, err = newResourceAggregateSpool(file, 3, 0)
+	if err != nil {
+		t.Fatalf("cannot reload spool: %v", err)
+	}
+	list := s.list()
+	if len(list) != 3 || list[0].Command != publishResourceCommand || string(list[1].Request) != "a2" {
+		t.Fatalf("unexpected commands %v after reload", list)
+	}
+	if list[0].accessToken != "" {
+		t.Fatalf("access token was persisted")
+	}
+	s.remove(list[0])
+	s.add(notifyResourceChangedCommand, "a", []byte("a3"), "token")
+	s.remove(list[1])
+	if list := s.list(); len(list) != 2 || string(list[0].Request) != "a3" {
+		t.Fatalf("change replaced during replay was removed: %v", list)
+	}
+
+	s.add(unpublishResourceCommand, "a", []byte("unpublish a"), "token")
+	s.add(notifyResourceChangedCommand, "a", []byte("a4"), "token")
+	s.add(publishResourceCommand, "d", []byte("publish d"), "token")
+	list = s.list()
+	if len(list) != 3 || list[0].Command != unpublishResourceCommand || list[2].ResourceID != "d" {
+		t.Fatalf("oldest command was not dropped: %v", list)
+	}
+}
+
+func TestResourceAggregateSpoolMaxBytes(t *testing.T) {
+	s, err := newResourceAggregateSpool("", 10, 4)
+	if err != nil {
+		t.Fatalf("cannot create spool: %v", err)
+	}
+	s.add(notifyResourceChangedCommand, "a", []byte("aa"), "")
+	s.add(notifyResourceChangedCommand, "b", []byte("bbb"), "")
+	if list := s.list(); len(list) != 1 || list[0].ResourceID != "b" || s.bytes != 3 || s.pending("a") {
+		t.Fatalf("unexpected entries %v of %v bytes", list, s.bytes)
+	}
+}
+
+func TestMarshalWithoutAccessToken(t *testing.T) {
+	authContext := commands.AuthorizationContext{DeviceId: "a", AccessToken: "token"}
+	request := commands.NotifyResourceChangedRequest{AuthorizationContext: &authContext, ResourceId: "r"}
+	if _, accessToken, err := marshalWithoutAccessToken(&request); err != nil || accessToken != "token" {
+		t.Fatalf("unexpected access token %v: %v", accessToken, err)
+	}
+	if authContext.AccessToken != "token" {
+		t.Fatalf("access token of request was not restored")
+	}
+}
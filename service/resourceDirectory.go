@@ -2,12 +2,15 @@ package service
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	coap "github.com/go-ocf/go-coap"
-	"github.com/go-ocf/kit/http"
 	"github.com/go-ocf/kit/log"
 	"github.com/go-ocf/resources/protobuf/resources"
 	"github.com/go-ocf/resources/protobuf/resources/commands"
@@ -27,6 +30,51 @@ type wkRd struct {
 	TimeToLive int                  `json:"ttl"`
 }
 
+//wkRdLinkPolicies policy of a published link, it contains notification intervals which are not part of resources.Policies
+type wkRdLinkPolicies struct {
+	BitFlags int32 `json:"bm"`
+	Pmin     int   `json:"pmin"` // seconds
+	Pmax     int   `json:"pmax"` // seconds
+}
+
+type wkRdLink struct {
+	resources.Resource
+	Policies *wkRdLinkPolicies `json:"p"`
+}
+
+func (l wkRdLink) resource() resources.Resource {
+	res := l.Resource
+	res.Policies = nil
+	if l.Policies != nil {
+		res.Policies = &resources.Policies{BitFlags: l.Policies.BitFlags}
+	}
+	return res
+}
+
+//wkRdPublish is a publish request of links
+type wkRdPublish struct {
+	DeviceID   string     `json:"di"`
+	Links      []wkRdLink `json:"links"`
+	TimeToLive int        `json:"ttl"`
+}
+
+//parseLinkThrottling returns throttling of notifications defined by policy of links, links with invalid intervals are ignored
+func parseLinkThrottling(links []wkRdLink) map[string]notificationThrottling {
+	throttling := make(map[string]notificationThrottling)
+	for _, link := range links {
+		if link.Policies == nil || (link.Policies.Pmin == 0 && link.Policies.Pmax == 0) {
+			continue
+		}
+		t, err := newNotificationThrottling(time.Duration(link.Policies.Pmin)*time.Second, time.Duration(link.Policies.Pmax)*time.Second)
+		if err != nil {
+			log.Errorf("Cannot use policy of link ocf://%v%v: %v", link.DeviceId, link.Href, err)
+			continue
+		}
+		throttling[link.DeviceId+link.Href] = t
+	}
+	return throttling
+}
+
 func parsePostPayload(msg coap.Message) (wkRd map[string]interface{}, err error) {
 	err = codec.NewDecoderBytes(msg.Payload(), new(codec.CborHandle)).Decode(&wkRd)
 	if err != nil {
@@ -54,6 +102,13 @@ func resource2UUID(deviceID, href string) string {
 	return uuid.NewV5(uuid.NamespaceURL, deviceID+href).String()
 }
 
+//resource2InstanceID derives a stable instance ID from the resource UUID, so ins stays the same across reconnects and restarts.
+//It is limited to 53 bits so it fits to a JSON number without loss of precision.
+func resource2InstanceID(deviceID, href string) int64 {
+	id := uuid.NewV5(uuid.NamespaceURL, deviceID+href)
+	return int64(binary.BigEndian.Uint64(id[:8]) >> 11)
+}
+
 func postResourcePublishURI(server *Server) string {
 	return server.ResourceProtocol + "://" + server.ResourceHost + uri.PublishResource
 }
@@ -62,18 +117,27 @@ func postResourceUnpublishURI(server *Server) string {
 	return server.ResourceProtocol + "://" + server.ResourceHost + uri.UnpublishResource
 }
 
-func publishResource(resource resources.Resource, server *Server, req *coap.Request, httpRequestCtx *http.RequestCtx, authContext commands.AuthorizationContext, ttl int32, links []resources.Resource) []resources.Resource {
+type publishResult int
+
+const (
+	resourceNotPublished publishResult = iota
+	resourcePublished
+	resourceSpooled // resource aggregate is unavailable, the publish is sent when it recovers
+)
+
+func publishResource(resource resources.Resource, server *Server, remoteAddr net.Addr, authContext commands.AuthorizationContext, ttl int32) (resources.Resource, publishResult) {
 	if resource.DeviceId == "" {
-		log.Error("cannot publish a resource without device ID for client %v", req.Client.RemoteAddr())
-		return links
+		log.Errorf("cannot publish a resource without device ID for client %v", remoteAddr)
+		return resource, resourceNotPublished
 	}
 
 	if resource.Href == "" {
-		log.Error("cannot publish a resource without a href for client %v", req.Client.RemoteAddr())
-		return links
+		log.Errorf("cannot publish a resource without a href for client %v", remoteAddr)
+		return resource, resourceNotPublished
 	}
 
 	resource.Id = resource2UUID(resource.DeviceId, resource.Href)
+	resource.InstanceId = resource2InstanceID(resource.DeviceId, resource.Href)
 
 	request := commands.PublishResourceRequest{
 		AuthorizationContext: &authContext,
@@ -82,25 +146,25 @@ func publishResource(resource resources.Resource, server *Server, req *coap.Requ
 		Resource:             &resource,
 		TimeToLive:           ttl,
 	}
-	var response commands.PublishResourceResponse
-	httpCode, err := httpRequestCtx.PostProto(server.httpClient, postResourcePublishURI(server), &request, &response)
+	httpCode, spooled, err := sendResourceAggregateCommand(server, publishResourceCommand, resource.Id, &request)
 	if err != nil {
 		log.Errorf("cannot publish resource ID:%v for device ID:%v", resource.Id, resource.DeviceId)
 	}
 
-	if httpCode == fasthttp.StatusOK {
-		resource.InstanceId = response.InstanceId
-		links = append(links, resource)
-		log.Info("resource successfull published for resource %v, device ID", resource.Id, resource.DeviceId)
-	} else {
-		log.Error("cannot publish resource ID:%v for device ID:%v", resource.Id, resource.DeviceId)
+	switch {
+	case spooled:
+		log.Infof("publish of resource %v for device %v is spooled", resource.Id, resource.DeviceId)
+		return resource, resourceSpooled
+	case httpCode == fasthttp.StatusOK:
+		log.Infof("resource successfull published for resource %v, device ID %v", resource.Id, resource.DeviceId)
+		return resource, resourcePublished
 	}
-
-	return links
+	log.Errorf("cannot publish resource ID:%v for device ID:%v", resource.Id, resource.DeviceId)
+	return resource, resourceNotPublished
 }
 
 func resourceDirectoryPublishHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
-	var w wkRd
+	var w wkRdPublish
 	var cborHandle codec.CborHandle
 	err := codec.NewDecoder(bytes.NewBuffer(req.Msg.Payload()), &cborHandle).Decode(&w)
 	if err != nil {
@@ -115,9 +179,6 @@ func resourceDirectoryPublishHandler(s coap.ResponseWriter, req *coap.Request, s
 		return
 	}
 
-	httpRequestCtx := http.AcquireRequestCtx()
-	defer http.ReleaseRequestCtx(httpRequestCtx)
-
 	session := server.clientContainer.find(req.Client.RemoteAddr().String())
 	if session == nil {
 		log.Errorf("Could not find a valid session for client %v", req.Client.RemoteAddr())
@@ -125,81 +186,161 @@ func resourceDirectoryPublishHandler(s coap.ResponseWriter, req *coap.Request, s
 		return
 	}
 	authContext := session.loadAuthorizationContext()
-
-	links := make([]resources.Resource, 0, len(w.Links))
-	for _, resource := range w.Links {
-		links = publishResource(resource, server, req, httpRequestCtx, authContext, int32(w.TimeToLive), links)
+	if authContext.DeviceId == "" {
+		log.Errorf("Client %v cannot publish resources before sign-in", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Unauthorized, nil)
+		return
 	}
 
+	requested := make([]resources.Resource, 0, len(w.Links))
+	for _, link := range w.Links {
+		requested = append(requested, link.resource())
+	}
+	links, spooled := publishLinks(server, session, req.Client.RemoteAddr(), authContext, requested, w.TimeToLive, parseLinkThrottling(w.Links))
+	if len(links) == 0 && len(spooled) > 0 {
+		log.Errorf("resource aggregate is unavailable, publish of links of device %v is spooled", w.DeviceID)
+		sendResponse(s, req.Client, coap.ServiceUnavailable, nil)
+		onLinksPublished(server, session, spooled)
+		return
+	}
 	if len(links) == 0 {
-		log.Error("empty links for device %v", w.DeviceID)
+		log.Errorf("empty links for device %v", w.DeviceID)
 		sendResponse(s, req.Client, coap.BadRequest, nil)
 		return
 	}
 
-	w.Links = links
-
-	for _, res := range links {
-		err := session.observeResource(res)
-		if err != nil {
-			log.Errorf("cannot observe published resource %v for device %v", res.Id, res.DeviceId)
-		}
-	}
-
 	out := bytes.NewBuffer(make([]byte, 0, 1024))
-	err = codec.NewEncoder(out, &cborHandle).Encode(w)
+	err = codec.NewEncoder(out, &cborHandle).Encode(wkRd{DeviceID: w.DeviceID, Links: links, TimeToLive: w.TimeToLive})
 	if err != nil {
 		log.Errorf("cannot marshal response for client %v: %v", req.Client.RemoteAddr(), err)
 		sendResponse(s, req.Client, coap.InternalServerError, nil)
 		return
 	}
 	sendResponse(s, req.Client, coap.Changed, out.Bytes())
+	onLinksPublished(server, session, append(links, spooled...))
 }
 
-func parseUnpublishQueryString(queries []interface{}, deviceID *string, instanceIDs []int64) ([]int64, error) {
-	deviceIDFound := false
+//publishLinks publishes links to resource aggregate and observes the published ones. Links whose publish was spooled
+//are returned separately, they are observed too, so their changes follow the publish.
+func publishLinks(server *Server, session *Session, remoteAddr net.Addr, authContext commands.AuthorizationContext, links []resources.Resource, ttl int, linkThrottling map[string]notificationThrottling) (published, spooled []resources.Resource) {
+	published = make([]resources.Resource, 0, len(links))
+	for _, resource := range links {
+		// A device which doesn't sign in itself (eg. behind a bridge) is claimed by the user of the publishing session.
+		// The claim is trusted only until DEVICE_CLAIM_TTL after the last publish and the sign-in of the device overrides it.
+		if resource.DeviceId != authContext.DeviceId && !server.deviceOwners.claim(resource.DeviceId, authContext.UserId) {
+			log.Errorf("Device %v of user %v cannot publish resource %v of device %v owned by another user", authContext.DeviceId, authContext.UserId, resource.Href, resource.DeviceId)
+			continue
+		}
+		switch res, result := publishResource(resource, server, remoteAddr, authContext, int32(ttl)); result {
+		case resourcePublished:
+			published = append(published, res)
+		case resourceSpooled:
+			spooled = append(spooled, res)
+		}
+	}
+	accepted := append(append(make([]resources.Resource, 0, len(published)+len(spooled)), published...), spooled...)
+	if len(accepted) == 0 {
+		return published, spooled
+	}
+	server.resourceStore.add(authContext.GetDeviceId(), accepted)
+
+	for _, res := range accepted {
+		err := session.observeResource(res, ttl, linkThrottling[res.DeviceId+res.Href])
+		if err != nil {
+			log.Errorf("cannot observe published resource %v for device %v", res.Id, res.DeviceId)
+		}
+	}
+	for _, deviceID := range publishedDeviceIDs(accepted) {
+		if isDeviceOwner(server, authContext, deviceID) {
+			server.clientContainer.indexDevice(deviceID, session)
+		}
+	}
+	return published, spooled
+}
+
+//onLinksPublished delivers queued commands and desired states to devices which published their resources
+func onLinksPublished(server *Server, session *Session, links []resources.Resource) {
+	for _, deviceID := range publishedDeviceIDs(links) {
+		go server.flushCommandQueue(deviceID)
+		go server.reconcileDevice(session, deviceID)
+	}
+}
+
+func publishedDeviceIDs(links []resources.Resource) []string {
+	deviceIDs := make([]string, 0, 1)
+	for _, res := range links {
+		if !containsString(deviceIDs, res.DeviceId) {
+			deviceIDs = append(deviceIDs, res.DeviceId)
+		}
+	}
+	return deviceIDs
+}
 
+func parseUnpublishQueryString(queries []interface{}) (deviceIDs []string, instanceIDs []int64, err error) {
 	for _, query := range queries {
-		q := strings.Split(query.(string), "=")
-		if len(q) == 2 {
-			switch q[0] {
-			case "di":
-				*deviceID = q[1]
-				deviceIDFound = true
-			case "ins":
-				i, err := strconv.Atoi(q[1])
-				if err != nil {
-					log.Errorf("Cannot convert %v to number", q[1])
-				}
-				instanceIDs = append(instanceIDs, int64(i))
+		q := strings.SplitN(query.(string), "=", 2)
+		if len(q) != 2 {
+			return nil, nil, fmt.Errorf("Invalid query %v", query)
+		}
+		switch q[0] {
+		case "di":
+			if q[1] == "" {
+				return nil, nil, fmt.Errorf("Empty device ID")
+			}
+			if !containsString(deviceIDs, q[1]) {
+				deviceIDs = append(deviceIDs, q[1])
 			}
+		case "ins":
+			i, err := strconv.ParseInt(q[1], 10, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("Cannot convert instance ID %v to number: %v", q[1], err)
+			}
+			instanceIDs = append(instanceIDs, i)
+		default:
+			return nil, nil, fmt.Errorf("Unsupported query %v", query)
 		}
 	}
 
-	if !deviceIDFound {
-		return nil, fmt.Errorf("DeviceID not found")
+	if len(deviceIDs) == 0 {
+		return nil, nil, fmt.Errorf("DeviceID not found")
 	}
 
-	return instanceIDs, nil
+	return deviceIDs, instanceIDs, nil
+}
+
+//isDeviceOwner returns true when the device is signed in by the session or it is owned by the signed-in user (eg. device behind a bridge)
+func isDeviceOwner(server *Server, authContext commands.AuthorizationContext, deviceID string) bool {
+	if authContext.DeviceId != "" && authContext.DeviceId == deviceID {
+		return true
+	}
+	return authContext.UserId != "" && server.deviceOwners.owner(deviceID) == authContext.UserId
 }
 
-func unpublishResource(resource resources.Resource, server *Server, httpRequestCtx *http.RequestCtx, authContext commands.AuthorizationContext, deviceID string, rscsUnpublished map[string]bool) map[string]bool {
+type unpublishedInstances struct {
+	DeviceID    string  `json:"di"`
+	InstanceIDs []int64 `json:"ins"`
+}
+
+//unpublishResource sends unpublish through the spool, so it follows the spooled publish and changes of the resource
+func unpublishResource(resource resources.Resource, server *Server, authContext commands.AuthorizationContext, rscsUnpublished map[string]bool) map[string]bool {
 	request := commands.UnpublishResourceRequest{
 		AuthorizationContext: &authContext,
 		ResourceId:           resource.Id,
-		DeviceId:             deviceID,
+		DeviceId:             resource.DeviceId,
 	}
-	var response commands.UnpublishResourceResponse
-	httpCode, err := httpRequestCtx.PostProto(server.httpClient, postResourceUnpublishURI(server), &request, &response)
+	httpCode, spooled, err := sendResourceAggregateCommand(server, unpublishResourceCommand, resource.Id, &request)
 	if err != nil {
 		log.Errorf("cannot unpublish resource ID:%v for device ID:%v", resource.Id, resource.DeviceId)
 	}
 
-	if httpCode == fasthttp.StatusOK {
-		log.Info("resource %v successfully unpublished for device ID %v", resource.Id, resource.DeviceId)
+	if spooled {
+		log.Infof("unpublish of resource %v for device %v is spooled", resource.Id, resource.DeviceId)
+		rscsUnpublished[resource.Id] = true
+	} else if httpCode == fasthttp.StatusOK {
+		log.Infof("resource %v successfully unpublished for device ID %v", resource.Id, resource.DeviceId)
 		rscsUnpublished[resource.Id] = true
 	} else {
-		log.Error("cannot unpublish resource %v for device %v", resource.Id, resource.DeviceId)
+		log.Errorf("cannot unpublish resource %v for device %v", resource.Id, resource.DeviceId)
 		rscsUnpublished[resource.Id] = false
 	}
 
@@ -207,9 +348,6 @@ func unpublishResource(resource resources.Resource, server *Server, httpRequestC
 }
 
 func resourceDirectoryUnpublishHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
-	httpRequestCtx := http.AcquireRequestCtx()
-	defer http.ReleaseRequestCtx(httpRequestCtx)
-
 	session := server.clientContainer.find(req.Client.RemoteAddr().String())
 	if session == nil {
 		log.Errorf("Cannot find session for client %v", req.Client.RemoteAddr())
@@ -217,13 +355,16 @@ func resourceDirectoryUnpublishHandler(s coap.ResponseWriter, req *coap.Request,
 		return
 	}
 	authContext := session.loadAuthorizationContext()
+	if authContext.DeviceId == "" {
+		log.Errorf("Client %v cannot unpublish resources before sign-in", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Unauthorized, nil)
+		return
+	}
 
 	queries := req.Msg.Options(coap.URIQuery)
-	var deviceID string
-	inss := make([]int64, 0, 32)
-	inss, err := parseUnpublishQueryString(queries, &deviceID, inss)
+	deviceIDs, inss, err := parseUnpublishQueryString(queries)
 	if err != nil {
-		log.Errorf("Incorrect Unpublish query string - %v", err)
+		log.Errorf("Incorrect Unpublish query string from client %v - %v", req.Client.RemoteAddr(), err)
 		sendResponse(s, req.Client, coap.BadRequest, nil)
 		return
 	}
@@ -231,20 +372,62 @@ func resourceDirectoryUnpublishHandler(s coap.ResponseWriter, req *coap.Request,
 	rscs := make([]resources.Resource, 0, 32)
 	rscsUnpublished := make(map[string]bool, 32)
 
-	rscs = session.getObservedResources(deviceID, inss, rscs)
+	for _, deviceID := range deviceIDs {
+		if !isDeviceOwner(server, authContext, deviceID) {
+			log.Errorf("Client %v of device %v cannot unpublish resources of device %v", req.Client.RemoteAddr(), authContext.DeviceId, deviceID)
+			sendResponse(s, req.Client, coap.Forbidden, nil)
+			return
+		}
+		rscs = server.resourceStore.find(authContext.DeviceId, deviceID, inss, rscs)
+	}
 	if len(rscs) == 0 {
 		log.Errorf("no matching resources found for the DELETE request parameters - with device ID and instance IDs %v, ", queries)
-		sendResponse(s, req.Client, coap.BadRequest, nil)
+		sendResponse(s, req.Client, coap.NotFound, nil)
 		return
 	}
 
 	for _, resource := range rscs {
-		rscsUnpublished = unpublishResource(resource, server, httpRequestCtx, authContext, deviceID, rscsUnpublished)
+		rscsUnpublished = unpublishResource(resource, server, authContext, rscsUnpublished)
 	}
 
 	session.unobserveResources(rscs, rscsUnpublished)
 
-	sendResponse(s, req.Client, coap.Deleted, nil)
+	unpublished := make([]resources.Resource, 0, len(rscs))
+	for _, resource := range rscs {
+		if rscsUnpublished[resource.Id] {
+			unpublished = append(unpublished, resource)
+		}
+	}
+	server.resourceStore.remove(authContext.DeviceId, unpublished)
+	if len(unpublished) == 0 {
+		log.Errorf("cannot unpublish any of resources %v for client %v", queries, req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+
+	devices := make([]unpublishedInstances, 0, len(deviceIDs))
+	for _, deviceID := range deviceIDs {
+		d := unpublishedInstances{DeviceID: deviceID, InstanceIDs: make([]int64, 0, len(unpublished))}
+		for _, resource := range unpublished {
+			if resource.DeviceId == deviceID {
+				d.InstanceIDs = append(d.InstanceIDs, resource.InstanceId)
+			}
+		}
+		if len(d.InstanceIDs) > 0 {
+			sort.Slice(d.InstanceIDs, func(i, j int) bool { return d.InstanceIDs[i] < d.InstanceIDs[j] })
+			devices = append(devices, d)
+		}
+	}
+
+	var cborHandle codec.CborHandle
+	out := bytes.NewBuffer(make([]byte, 0, 1024))
+	err = codec.NewEncoder(out, &cborHandle).Encode(devices)
+	if err != nil {
+		log.Errorf("cannot marshal response for client %v: %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+	sendResponse(s, req.Client, coap.Deleted, out.Bytes())
 }
 
 type resourceDirectorySelector struct {
@@ -259,7 +442,11 @@ func resourceDirectoryGetSelector(s coap.ResponseWriter, req *coap.Request, serv
 		return
 	}
 
-	var rds resourceDirectorySelector //we want to use sel:0 to prefer cloud RD
+	authContext := session.loadAuthorizationContext()
+	sel, reason := server.rdSelectionPolicy.selectionCriteria(authContext.DeviceId, authContext.UserId, server.resourceCircuit.isOpen())
+	log.Infof("Resource directory selection %v (%v) for client %v, device %v, user %v", sel, reason, req.Client.RemoteAddr(), authContext.DeviceId, authContext.UserId)
+	rdSelectionsMetric.Add(fmt.Sprintf("%v:%v", sel, reason), 1)
+	rds := resourceDirectorySelector{SelectionCriteria: sel}
 
 	var cborHandle codec.CborHandle
 	out := bytes.NewBuffer(make([]byte, 0, 1024))
@@ -273,6 +460,95 @@ func resourceDirectoryGetSelector(s coap.ResponseWriter, req *coap.Request, serv
 	sendResponse(s, req.Client, coap.Content, out.Bytes())
 }
 
+//findUserSessions returns sessions of the same user as the session which can contain devices of the filter
+func findUserSessions(server *Server, session *Session, filter resourceFilter) []*Session {
+	authContext := session.loadAuthorizationContext()
+	if authContext.UserId == "" {
+		return []*Session{session}
+	}
+	if len(filter.deviceIDs) == 0 {
+		return server.clientContainer.findByUserID(authContext.UserId)
+	}
+	sessions := make([]*Session, 0, len(filter.deviceIDs))
+	for _, deviceID := range filter.deviceIDs {
+		s := server.clientContainer.findByDeviceID(deviceID)
+		if s == nil {
+			continue
+		}
+		deviceAuthContext := s.loadAuthorizationContext()
+		if deviceAuthContext.UserId != authContext.UserId {
+			continue
+		}
+		duplicate := false
+		for _, v := range sessions {
+			duplicate = duplicate || v == s
+		}
+		if !duplicate {
+			sessions = append(sessions, s)
+		}
+	}
+	return sessions
+}
+
+//findPublishedResources returns links published by the session, or by all sessions of the same user when the session is signed in
+func findPublishedResources(server *Server, session *Session, filter resourceFilter) []wkRd {
+	sessions := findUserSessions(server, session, filter)
+
+	devices := make(map[string]*wkRd)
+	for _, s := range sessions {
+		s.findObservedResources(filter, devices)
+	}
+
+	result := make([]wkRd, 0, len(devices))
+	for _, device := range devices {
+		sort.Slice(device.Links, func(i, j int) bool { return device.Links[i].Href < device.Links[j].Href })
+		result = append(result, *device)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].DeviceID < result[j].DeviceID })
+	return result
+}
+
+func resourceDirectoryLookupHandler(s coap.ResponseWriter, req *coap.Request, server *Server, filter resourceFilter) {
+	session := server.clientContainer.find(req.Client.RemoteAddr().String())
+	if session == nil {
+		log.Errorf("Cannot find session for client %v", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+
+	devices := findPublishedResources(server, session, filter)
+	if len(devices) == 0 {
+		log.Infof("no published resources match the lookup %v of client %v", req.Msg.Options(coap.URIQuery), req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.NotFound, nil)
+		return
+	}
+
+	var cborHandle codec.CborHandle
+	out := bytes.NewBuffer(make([]byte, 0, 1024))
+	err := codec.NewEncoder(out, &cborHandle).Encode(devices)
+	if err != nil {
+		log.Errorf("cannot marshal response for client %v: %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+
+	sendResponse(s, req.Client, coap.Content, out.Bytes())
+}
+
+func resourceDirectoryGetHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	filter, err := parseResourceFilter(req.Msg.Options(coap.URIQuery))
+	if err != nil {
+		log.Errorf("Incorrect lookup query string from client %v - %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.BadRequest, nil)
+		return
+	}
+	if filter.isEmpty() {
+		resourceDirectoryGetSelector(s, req, server)
+		return
+	}
+	resourceDirectoryLookupHandler(s, req, server, filter)
+}
+
 func resourceDirectoryHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
 	switch req.Msg.Code() {
 	case coap.POST:
@@ -280,7 +556,7 @@ func resourceDirectoryHandler(s coap.ResponseWriter, req *coap.Request, server *
 	case coap.DELETE:
 		resourceDirectoryUnpublishHandler(s, req, server)
 	case coap.GET:
-		resourceDirectoryGetSelector(s, req, server)
+		resourceDirectoryGetHandler(s, req, server)
 	default:
 		log.Errorf("Forbidden request from %v", req.Client.RemoteAddr())
 		sendResponse(s, req.Client, coap.Forbidden, nil)
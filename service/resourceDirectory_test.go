@@ -80,12 +80,12 @@ var tblResourceDirectory = []testEl{
 	{"BadRequest5", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"" } ], "ttl":12345}`, nil}, output{coap.BadRequest, ``, nil}},
 	{"BadRequest5", input{coap.POST, `{ "di":"a", "links":[ { "href":"" } ], "ttl":12345}`, nil}, output{coap.BadRequest, ``, nil}},
 	{"Changed0", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"/a" } ], "ttl":12345}`, nil},
-		output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/a","id":"b2c5f775-9a6f-5d5b-a82a-eaa1d23f0629","if":null,"ins":0,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
-	{"Changed1", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"/b" } ], "ttl":12345}`, nil}, output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/b","id":"91410e86-9161-5317-9576-be5c7660f085","if":null,"ins":1,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
+		output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/a","id":"b2c5f775-9a6f-5d5b-a82a-eaa1d23f0629","if":null,"ins":1786426932022763,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
+	{"Changed1", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"/b" } ], "ttl":12345}`, nil}, output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/b","id":"91410e86-9161-5317-9576-be5c7660f085","if":null,"ins":607075655887914,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
 	{"Changed2", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"/b" }, { "di":"a", "href":"/c" }], "ttl":12345}`, nil},
-		output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/b","id":"91410e86-9161-5317-9576-be5c7660f085","if":null,"ins":2,"p":null,"rt":null,"type":null},{"di":"a","href":"/c","id":"7d8daabb-7a03-5a06-8ef9-b2e8d41bd427","if":null,"ins":3,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
+		output{coap.Changed, `{"di":"a","links":[{"di":"a","href":"/b","id":"91410e86-9161-5317-9576-be5c7660f085","if":null,"ins":607075655887914,"p":null,"rt":null,"type":null},{"di":"a","href":"/c","id":"7d8daabb-7a03-5a06-8ef9-b2e8d41bd427","if":null,"ins":4417517064765547,"p":null,"rt":null,"type":null}],"ttl":12345}`, nil}},
 	{"Changed3", input{coap.POST, `{ "di":"b", "links":[ { "di":"b", "href":"/c", "p": {"bm":2} } ], "ttl":12345}`, nil},
-		output{coap.Changed, `{"di":"b","links":[{"di":"b","href":"/c","id":"a2ccb45a-a892-515c-b153-79d1b903cc31","if":null,"ins":4,"p":{"bm":2},"rt":null,"type":null}],"ttl":12345}`, nil}},
+		output{coap.Changed, `{"di":"b","links":[{"di":"b","href":"/c","id":"a2ccb45a-a892-515c-b153-79d1b903cc31","if":null,"ins":1224403024417354,"p":{"bm":2},"rt":null,"type":null}],"ttl":12345}`, nil}},
 }
 
 func testValidateResp(t *testing.T, test testEl, resp coap.Message) {
@@ -192,6 +192,14 @@ func TestResourceDirectoryPostHandler(t *testing.T) {
 	resourceServer := "127.0.0.1:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
 	os.Setenv("NETWORK", "tcp")
 	os.Setenv("RESOURCE_HOST", resourceServer)
+	sauth, authAddrstr, authfin := testCreateAuthServer(t)
+	os.Setenv("AUTH_HOST", authAddrstr)
+	defer func() {
+		sauth.Shutdown()
+		if err := <-authfin; err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
 	s, addrstr, fin, err := testCreateCoapGateway(t)
 	if err != nil {
 		t.Fatalf("unable to run test server: %v", err)
@@ -211,6 +219,9 @@ func TestResourceDirectoryPostHandler(t *testing.T) {
 	}
 	defer co.Close()
 
+	testPostHandler(t, resourceDirectory, testEl{"Unauthorized", input{coap.POST, `{ "di":"a", "links":[ { "di":"a", "href":"/a" } ], "ttl":12345}`, nil}, output{coap.Unauthorized, ``, nil}}, co)
+	testSignInDevice(t, co, "a")
+
 	for _, test := range tblResourceDirectory {
 		tf := func(t *testing.T) {
 			testPostHandler(t, resourceDirectory, test, co)
@@ -223,11 +234,14 @@ func TestResourceDirectoryDeleteHandler(t *testing.T) {
 	//set counter 0, when other test run with this that it can be modified
 	counter = 0
 	deletetblResourceDirectory := []testEl{
-		{"NotExist1", input{coap.DELETE, ``, []string{"di=c", "ins=5"}}, output{coap.BadRequest, ``, nil}},   // Non-existent device ID.
-		{"NotExist2", input{coap.DELETE, ``, []string{"ins=4"}}, output{coap.BadRequest, ``, nil}},           // Device ID empty.
-		{"NotExist3", input{coap.DELETE, ``, []string{"di=a", "ins=999"}}, output{coap.BadRequest, ``, nil}}, // Instance ID non-existent.
-		{"Exist1", input{coap.DELETE, ``, []string{"di=a"}}, output{coap.Deleted, ``, nil}},                  // If instanceIDs empty, all instances for a given device ID should be unpublished.
-		{"Exist2", input{coap.DELETE, ``, []string{"di=b", "ins=4", "ins=5"}}, output{coap.Deleted, ``, nil}},
+		{"NotExist1", input{coap.DELETE, ``, []string{"di=c", "ins=5"}}, output{coap.Forbidden, ``, nil}},  // Device ID is not owned by the client.
+		{"NotExist2", input{coap.DELETE, ``, []string{"ins=4"}}, output{coap.BadRequest, ``, nil}},         // Device ID empty.
+		{"NotExist3", input{coap.DELETE, ``, []string{"di=a", "ins=999"}}, output{coap.NotFound, ``, nil}}, // Instance ID non-existent.
+		{"BadRequest0", input{coap.DELETE, ``, []string{"di=a", "ins=abc"}}, output{coap.BadRequest, ``, nil}},
+		{"BadRequest1", input{coap.DELETE, ``, []string{"di=a", "xyz"}}, output{coap.BadRequest, ``, nil}},
+		{"Exist0", input{coap.DELETE, ``, []string{"di=a", "di=b", "ins=607075655887914", "ins=1224403024417354"}}, output{coap.Deleted, `[{"di":"a","ins":[607075655887914]},{"di":"b","ins":[1224403024417354]}]`, nil}}, // Bridge unpublishes resources of more devices.
+		{"Exist1", input{coap.DELETE, ``, []string{"di=a"}}, output{coap.Deleted, `[{"di":"a","ins":[1786426932022763,4417517064765547]}]`, nil}},                                                                          // If instanceIDs empty, all instances for a given device ID should be unpublished.
+		{"Exist2", input{coap.DELETE, ``, []string{"di=b", "ins=1224403024417354"}}, output{coap.NotFound, ``, nil}},                                                                                                       // All resources of device was unpublished.
 	}
 
 	mux := http.NewServeMux()
@@ -239,6 +253,14 @@ func TestResourceDirectoryDeleteHandler(t *testing.T) {
 	resourceServer := "127.0.0.1:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
 	os.Setenv("NETWORK", "tcp")
 	os.Setenv("RESOURCE_HOST", resourceServer)
+	sauth, authAddrstr, authfin := testCreateAuthServer(t)
+	os.Setenv("AUTH_HOST", authAddrstr)
+	defer func() {
+		sauth.Shutdown()
+		if err := <-authfin; err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
 	s, addrstr, fin, err := testCreateCoapGateway(t)
 	if err != nil {
 		t.Fatalf("unable to run test server: %v", err)
@@ -258,6 +280,8 @@ func TestResourceDirectoryDeleteHandler(t *testing.T) {
 	}
 	defer co.Close()
 
+	testSignInDevice(t, co, "a")
+
 	// Publish resources first!
 	for _, test := range tblResourceDirectory {
 		tf := func(t *testing.T) {
@@ -331,3 +355,85 @@ func TestResourceDirectoryGetSelector(t *testing.T) {
 		t.Run(test.name, tf)
 	}
 }
+
+func testGetHandler(t *testing.T, path string, test testEl, co *coap.ClientConn) {
+	req, err := co.NewGetRequest(path)
+	if err != nil {
+		t.Fatalf("cannot create request: %v", err)
+	}
+	for _, q := range test.in.queries {
+		req.AddOption(coap.URIQuery, q)
+	}
+
+	resp, err := co.Exchange(req)
+	if err != nil {
+		t.Fatalf("Cannot send/retrieve msg: %v", err)
+	}
+	testValidateResp(t, test, resp)
+}
+
+func TestResourceDirectoryLookup(t *testing.T) {
+	tbl := []testEl{
+		{"BadRequest", input{coap.GET, ``, []string{"ins=abc"}}, output{coap.BadRequest, ``, nil}},
+		{"UnknownQuery", input{coap.GET, ``, []string{"dev=a"}}, output{coap.BadRequest, ``, nil}},
+		{"NotFound0", input{coap.GET, ``, []string{"di=c"}}, output{coap.NotFound, ``, nil}},
+		{"NotFound1", input{coap.GET, ``, []string{"di=b", "rt=oic.r.light"}}, output{coap.NotFound, ``, nil}},
+		{"Content0", input{coap.GET, ``, []string{"di=b"}},
+			output{coap.Content, `[{"di":"b","links":[{"di":"b","href":"/c","id":"a2ccb45a-a892-515c-b153-79d1b903cc31","if":null,"ins":1224403024417354,"p":{"bm":2},"rt":null,"type":null}],"ttl":12345}]`, nil}},
+		{"Content1", input{coap.GET, ``, []string{"href=/a", "href=/c"}},
+			output{coap.Content, `[{"di":"a","links":[{"di":"a","href":"/a","id":"b2c5f775-9a6f-5d5b-a82a-eaa1d23f0629","if":null,"ins":1786426932022763,"p":null,"rt":null,"type":null},{"di":"a","href":"/c","id":"7d8daabb-7a03-5a06-8ef9-b2e8d41bd427","if":null,"ins":4417517064765547,"p":null,"rt":null,"type":null}],"ttl":12345},{"di":"b","links":[{"di":"b","href":"/c","id":"a2ccb45a-a892-515c-b153-79d1b903cc31","if":null,"ins":1224403024417354,"p":{"bm":2},"rt":null,"type":null}],"ttl":12345}]`, nil}},
+		{"Content2", input{coap.GET, ``, []string{"di=a", "ins=607075655887914"}},
+			output{coap.Content, `[{"di":"a","links":[{"di":"a","href":"/b","id":"91410e86-9161-5317-9576-be5c7660f085","if":null,"ins":607075655887914,"p":null,"rt":null,"type":null}],"ttl":12345}]`, nil}},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(uri.PublishResource, handleResPublishMocked(t))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	resourceServer := "127.0.0.1:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)
+	os.Setenv("NETWORK", "tcp")
+	os.Setenv("RESOURCE_HOST", resourceServer)
+	sauth, authAddrstr, authfin := testCreateAuthServer(t)
+	os.Setenv("AUTH_HOST", authAddrstr)
+	defer func() {
+		sauth.Shutdown()
+		if err := <-authfin; err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
+	s, addrstr, fin, err := testCreateCoapGateway(t)
+	if err != nil {
+		t.Fatalf("unable to run test server: %v", err)
+	}
+	defer func() {
+		s.Shutdown()
+		err := <-fin
+		if err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
+
+	client := &coap.Client{Net: "tcp"}
+	co, err := client.Dial(addrstr)
+	if err != nil {
+		t.Fatalf("unable to dialing: %v", err)
+	}
+	defer co.Close()
+
+	testSignInDevice(t, co, "a")
+
+	for _, test := range tblResourceDirectory {
+		tf := func(t *testing.T) {
+			testPostHandler(t, resourceDirectory, test, co)
+		}
+		t.Run(test.name, tf)
+	}
+
+	for _, test := range tbl {
+		tf := func(t *testing.T) {
+			testGetHandler(t, resourceDirectory, test, co)
+		}
+		t.Run(test.name, tf)
+	}
+}
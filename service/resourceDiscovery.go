@@ -0,0 +1,73 @@
+package service
+
+import (
+	"bytes"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/ugorji/go/codec"
+)
+
+var resourceDiscovery = "/oic/res"
+
+type discoveredDevice struct {
+	DeviceID string               `json:"di"`
+	Links    []resources.Resource `json:"links"`
+}
+
+func resourceDiscoveryGetHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	session := server.clientContainer.find(req.Client.RemoteAddr().String())
+	if session == nil {
+		log.Errorf("Cannot find session for client %v", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+	authContext := session.loadAuthorizationContext()
+	if authContext.UserId == "" {
+		log.Errorf("Cannot discover resources for client %v: not signed in", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Unauthorized, nil)
+		return
+	}
+
+	filter, err := parseResourceFilter(req.Msg.Options(coap.URIQuery))
+	if err != nil {
+		log.Errorf("Incorrect discovery query string from client %v - %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.BadRequest, nil)
+		return
+	}
+
+	devices := findPublishedResources(server, session, filter)
+	if len(devices) == 0 {
+		log.Infof("no resources match the discovery %v of client %v", req.Msg.Options(coap.URIQuery), req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.NotFound, nil)
+		return
+	}
+	discovered := make([]discoveredDevice, 0, len(devices))
+	for _, d := range devices {
+		discovered = append(discovered, discoveredDevice{DeviceID: d.DeviceID, Links: d.Links})
+	}
+
+	var cborHandle codec.CborHandle
+	out := bytes.NewBuffer(make([]byte, 0, 1024))
+	err = codec.NewEncoder(out, &cborHandle).Encode(discovered)
+	if err != nil {
+		log.Errorf("cannot marshal response for client %v: %v", req.Client.RemoteAddr(), err)
+		sendResponse(s, req.Client, coap.InternalServerError, nil)
+		return
+	}
+
+	sendResponse(s, req.Client, coap.Content, out.Bytes())
+}
+
+// Discovery of resources published by devices of the signed-in user
+// https://github.com/openconnectivityfoundation/core/blob/master/oic.wk.res.raml
+func resourceDiscoveryHandler(s coap.ResponseWriter, req *coap.Request, server *Server) {
+	switch req.Msg.Code() {
+	case coap.GET:
+		resourceDiscoveryGetHandler(s, req, server)
+	default:
+		log.Errorf("Forbidden request from %v", req.Client.RemoteAddr())
+		sendResponse(s, req.Client, coap.Forbidden, nil)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/uri"
+)
+
+func TestResourceDiscoveryGetHandler(t *testing.T) {
+	tbl := []testEl{
+		{"NotFound", input{coap.GET, ``, []string{"di=xyz"}}, output{coap.NotFound, ``, nil}},
+		{"BadRequest", input{coap.GET, ``, []string{"ins=abc"}}, output{coap.BadRequest, ``, nil}},
+		{"Content0", input{coap.GET, ``, nil},
+			output{coap.Content, `[{"di":"abc","links":[{"di":"abc","href":"/light","id":"94dfda8d-3930-5854-980f-ff4020547e57","if":null,"ins":5238053289797131,"p":null,"rt":["oic.r.light"],"type":null}]}]`, nil}},
+		{"Content1", input{coap.GET, ``, []string{"rt=oic.r.light", "di=abc"}},
+			output{coap.Content, `[{"di":"abc","links":[{"di":"abc","href":"/light","id":"94dfda8d-3930-5854-980f-ff4020547e57","if":null,"ins":5238053289797131,"p":null,"rt":["oic.r.light"],"type":null}]}]`, nil}},
+	}
+
+	sauth, authAddrstr, authfin := testCreateAuthServer(t)
+	defer func() {
+		sauth.Shutdown()
+		if err := <-authfin; err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
+	mux := http.NewServeMux()
+	mux.HandleFunc(uri.PublishResource, handleResPublishMocked(t))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	os.Setenv("NETWORK", "tcp")
+	os.Setenv("AUTH_HOST", authAddrstr)
+	os.Setenv("RESOURCE_HOST", "127.0.0.1:"+strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port))
+	s, addrstr, fin, err := testCreateCoapGateway(t)
+	if err != nil {
+		t.Fatalf("unable to run test server: %v", err)
+	}
+	defer func() {
+		s.Shutdown()
+		err := <-fin
+		if err != nil {
+			t.Fatalf("server unexcpected shutdown: %v", err)
+		}
+	}()
+
+	client := &coap.Client{Net: "tcp"}
+	co, err := client.Dial(addrstr)
+	if err != nil {
+		t.Fatalf("unable to dialing: %v", err)
+	}
+	defer co.Close()
+
+	testGetHandler(t, resourceDiscovery, testEl{"Unauthorized", input{coap.GET, ``, nil}, output{coap.Unauthorized, ``, nil}}, co)
+	testPostHandler(t, signIn, testEl{"SignIn", input{coap.POST, `{"di": "abc", "uid":"0", "accesstoken":"123" }`, nil}, output{coap.Changed, `{"expiresin":1}`, nil}}, co)
+	testPostHandler(t, resourceDirectory, testEl{"Publish", input{coap.POST, `{ "di":"abc", "links":[ { "di":"abc", "href":"/light", "rt":["oic.r.light"] } ], "ttl":12345}`, nil},
+		output{coap.Changed, `{"di":"abc","links":[{"di":"abc","href":"/light","id":"94dfda8d-3930-5854-980f-ff4020547e57","if":null,"ins":5238053289797131,"p":null,"rt":["oic.r.light"],"type":null}],"ttl":12345}`, nil}}, co)
+
+	for _, test := range tbl {
+		tf := func(t *testing.T) {
+			testGetHandler(t, resourceDiscovery, test, co)
+		}
+		t.Run(test.name, tf)
+	}
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//resourceFilter query filters of resource lookup, values of the same key are ORed, different keys are ANDed
+type resourceFilter struct {
+	deviceIDs     []string
+	resourceTypes []string
+	interfaces    []string
+	hrefs         []string
+	instanceIDs   []int64
+}
+
+func (f resourceFilter) isEmpty() bool {
+	return len(f.deviceIDs) == 0 && len(f.resourceTypes) == 0 && len(f.interfaces) == 0 && len(f.hrefs) == 0 && len(f.instanceIDs) == 0
+}
+
+//parseResourceFilter parses lookup queries strictly, unknown keys and malformed parts are refused
+func parseResourceFilter(queries []interface{}) (resourceFilter, error) {
+	var f resourceFilter
+	for _, query := range queries {
+		q := strings.SplitN(query.(string), "=", 2)
+		if len(q) != 2 || q[1] == "" {
+			return resourceFilter{}, fmt.Errorf("Invalid query '%v'", query)
+		}
+		switch q[0] {
+		case "di":
+			f.deviceIDs = append(f.deviceIDs, q[1])
+		case "rt":
+			f.resourceTypes = append(f.resourceTypes, q[1])
+		case "if":
+			f.interfaces = append(f.interfaces, q[1])
+		case "href":
+			f.hrefs = append(f.hrefs, q[1])
+		case "ins":
+			i, err := strconv.ParseInt(q[1], 10, 64)
+			if err != nil {
+				return resourceFilter{}, fmt.Errorf("Invalid instance ID %v: %v", q[1], err)
+			}
+			f.instanceIDs = append(f.instanceIDs, i)
+		default:
+			return resourceFilter{}, fmt.Errorf("Unknown query '%v'", q[0])
+		}
+	}
+	return f, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAnyString(values []string, wanted []string) bool {
+	for _, w := range wanted {
+		if containsString(values, w) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f resourceFilter) matchDeviceID(deviceID string) bool {
+	return len(f.deviceIDs) == 0 || containsString(f.deviceIDs, deviceID)
+}
+
+func (f resourceFilter) match(res resources.Resource) bool {
+	if !f.matchDeviceID(res.DeviceId) {
+		return false
+	}
+	if len(f.resourceTypes) > 0 && !containsAnyString(res.ResourceTypes, f.resourceTypes) {
+		return false
+	}
+	if len(f.interfaces) > 0 && !containsAnyString(res.Interfaces, f.interfaces) {
+		return false
+	}
+	if len(f.hrefs) > 0 && !containsString(f.hrefs, res.Href) {
+		return false
+	}
+	if len(f.instanceIDs) > 0 {
+		for _, ins := range f.instanceIDs {
+			if ins == res.InstanceId {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
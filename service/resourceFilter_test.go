@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseResourceFilter(t *testing.T) {
+	tbl := []struct {
+		name    string
+		queries []interface{}
+		wantErr bool
+	}{
+		{"Empty", nil, false},
+		{"Valid", []interface{}{"di=a", "rt=oic.r.light", "if=oic.if.baseline", "href=/a", "ins=1"}, false},
+		{"UnknownKey", []interface{}{"dev=a"}, true},
+		{"WithoutValue", []interface{}{"di"}, true},
+		{"EmptyValue", []interface{}{"di="}, true},
+		{"InvalidInstanceID", []interface{}{"ins=abc"}, true},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := parseResourceFilter(tt.queries)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("unexpected error %v", err)
+			}
+			if err == nil && f.isEmpty() != (len(tt.queries) == 0) {
+				t.Fatalf("unexpected filter %+v", f)
+			}
+		})
+	}
+}
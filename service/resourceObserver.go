@@ -0,0 +1,191 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+type observationState string
+
+const (
+	observationActive   observationState = "active"
+	observationRetrying observationState = "retrying"
+	observationFailed   observationState = "failed"
+)
+
+//observationBackoff configures re-establishing of failed observations
+type observationBackoff struct {
+	min   time.Duration // delay before the first retry, it is doubled by each next retry
+	max   time.Duration // maximal delay between retries
+	limit int           // number of retries before the observation is failed, zero means unlimited
+}
+
+func (b observationBackoff) delay(retries int) time.Duration {
+	d := b.min
+	for i := 1; i < retries && d < b.max; i++ {
+		d *= 2
+	}
+	if d > b.max {
+		d = b.max
+	}
+	return d
+}
+
+//resourceObserver observes a resource and re-establishes the observation when it cannot be created
+//or when the device ends it by an error or by a response without observe option
+type resourceObserver struct {
+	client        *deviceClient
+	res           resources.Resource
+	backoff       observationBackoff
+	notifications *notificationQueue
+	shadow        *resourceShadow
+	throttle      *notificationThrottle
+	sequence      observeSequence
+
+	observation *coap.Observation
+	state       observationState
+	retries     int
+	lastError   string
+	mutex       sync.Mutex
+
+	restart  chan string
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newResourceObserver(client *deviceClient, res resources.Resource, backoff observationBackoff, throttling notificationThrottling, notifications *notificationQueue, shadow *resourceShadow) *resourceObserver {
+	o := &resourceObserver{
+		client:        client,
+		res:           res,
+		backoff:       backoff,
+		notifications: notifications,
+		shadow:        shadow,
+		state:         observationRetrying,
+		restart:       make(chan string, 1),
+		done:          make(chan struct{}),
+	}
+	o.throttle = newNotificationThrottle(throttling, notifications.push, o.refresh)
+	go o.run()
+	return o
+}
+
+//refresh retrieves content of the resource, it is called when no notification was forwarded for pmax
+func (o *resourceObserver) refresh() {
+	resp, err := o.client.Get(o.res.Href)
+	if err != nil {
+		log.Errorf("Cannot refresh ocf://%v%v: %v", o.res.DeviceId, o.res.Href, err)
+		return
+	}
+	decodeMsgToDebug(resp, "onRefreshResponse")
+	if resp.Code() != coap.Content {
+		reportDeviceErrorResponse(o.res, "refresh", resp.Code())
+		return
+	}
+	o.received(resp)
+}
+
+//received updates the shadow by content from the device and passes it to the throttle
+func (o *resourceObserver) received(msg coap.Message) {
+	o.shadow.update(msg, time.Now())
+	o.throttle.notify(msg)
+}
+
+func (o *resourceObserver) setState(state observationState, observation *coap.Observation, lastError string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	if o.state != state {
+		log.Infof("observation of ocf://%v%v is %v", o.res.DeviceId, o.res.Href, state)
+	}
+	o.state = state
+	o.observation = observation
+	switch state {
+	case observationActive:
+		o.retries = 0
+	case observationRetrying:
+		o.retries++
+	}
+	if lastError != "" {
+		o.lastError = lastError
+	}
+}
+
+func (o *resourceObserver) health() (state observationState, retries int, lastError string) {
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+	return o.state, o.retries, o.lastError
+}
+
+func (o *resourceObserver) cancelObservation() {
+	o.mutex.Lock()
+	observation := o.observation
+	o.observation = nil
+	o.mutex.Unlock()
+	if observation == nil {
+		return
+	}
+	log.Infof("cancel observation of ocf://%v%v", o.res.DeviceId, o.res.Href)
+	if err := observation.Cancel(); err != nil {
+		log.Errorf("Cannot cancel observation ocf://%v%v: %v", o.res.DeviceId, o.res.Href, err)
+	}
+}
+
+func (o *resourceObserver) run() {
+	defer o.cancelObservation()
+	for {
+		o.sequence.reset()
+		observation, err := o.client.Observe(o.res.Href, func(req *coap.Request) {
+			onObserveNotification(o, req)
+		})
+		var reason string
+		if err != nil {
+			reason = fmt.Sprintf("cannot observe: %v", err)
+			log.Errorf("Cannot observe ocf://%v%v: %v", o.res.DeviceId, o.res.Href, err)
+		} else {
+			o.setState(observationActive, observation, "")
+			observationsMetric.Add(string(observationActive), 1)
+			select {
+			case <-o.done:
+				return
+			case reason = <-o.restart:
+			}
+			o.cancelObservation()
+		}
+
+		o.setState(observationRetrying, nil, reason)
+		observationsMetric.Add(string(observationRetrying), 1)
+		_, retries, _ := o.health()
+		if o.backoff.limit > 0 && retries > o.backoff.limit {
+			log.Errorf("Observation of ocf://%v%v failed after %v retries: %v", o.res.DeviceId, o.res.Href, o.backoff.limit, reason)
+			o.setState(observationFailed, nil, "")
+			observationsMetric.Add(string(observationFailed), 1)
+			o.throttle.stop()
+			return
+		}
+		select {
+		case <-o.done:
+			return
+		case <-time.After(o.backoff.delay(retries)):
+		}
+	}
+}
+
+//reobserve re-establishes the observation, it is called when the device ended the observation
+func (o *resourceObserver) reobserve(reason string) {
+	select {
+	case o.restart <- reason:
+	default:
+	}
+}
+
+//stop cancels the observation
+func (o *resourceObserver) stop() {
+	o.doneOnce.Do(func() {
+		o.throttle.stop()
+		close(o.done)
+	})
+}
@@ -0,0 +1,16 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestObservationBackoff(t *testing.T) {
+	b := observationBackoff{min: time.Second, max: 5 * time.Second}
+	expected := []time.Duration{time.Second, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}
+	for retries, e := range expected {
+		if d := b.delay(retries); d != e {
+			t.Fatalf("unexpected delay %v for %v retries, expected %v", d, retries, e)
+		}
+	}
+}
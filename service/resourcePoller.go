@@ -0,0 +1,105 @@
+package service
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//resourcePoller retrieves content of a non-observable resource when it is published and then periodically.
+//The ETag of the last content is sent with each request, so the device can answer 2.03 Valid when content is not changed.
+type resourcePoller struct {
+	client        *deviceClient
+	res           resources.Resource
+	interval      time.Duration // zero means the resource is retrieved only once
+	jitter        float64       // maximal random prolongation of interval as fraction of the interval
+	notifications *notificationQueue
+	shadow        *resourceShadow
+
+	etag     []byte
+	done     chan struct{}
+	doneOnce sync.Once
+}
+
+func newResourcePoller(client *deviceClient, res resources.Resource, interval time.Duration, jitter float64, notifications *notificationQueue, shadow *resourceShadow) *resourcePoller {
+	p := &resourcePoller{
+		client:        client,
+		res:           res,
+		interval:      interval,
+		jitter:        jitter,
+		notifications: notifications,
+		shadow:        shadow,
+		done:          make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *resourcePoller) nextWaitTime() time.Duration {
+	if p.jitter <= 0 {
+		return p.interval
+	}
+	return p.interval + time.Duration(rand.Float64()*p.jitter*float64(p.interval))
+}
+
+func (p *resourcePoller) run() {
+	p.poll()
+	if p.interval <= 0 {
+		return
+	}
+	log.Infof("polling of ocf://%v%v every %v", p.res.DeviceId, p.res.Href, p.interval)
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-time.After(p.nextWaitTime()):
+			p.poll()
+		}
+	}
+}
+
+func (p *resourcePoller) poll() {
+	req, err := p.client.NewGetRequest(p.res.Href)
+	if err != nil {
+		log.Errorf("Cannot create get request for ocf://%v%v: %v", p.res.DeviceId, p.res.Href, err)
+		return
+	}
+	if len(p.etag) > 0 {
+		req.SetOption(coap.ETag, p.etag)
+	}
+	resp, err := p.client.Exchange(req)
+	if err != nil {
+		log.Errorf("Cannot get ocf://%v%v: %v", p.res.DeviceId, p.res.Href, err)
+		return
+	}
+	switch resp.Code() {
+	case coap.Valid:
+		log.Debugf("content of ocf://%v%v is not changed", p.res.DeviceId, p.res.Href)
+		pollsMetric.Add("valid", 1)
+		p.shadow.confirm(time.Now())
+		return
+	case coap.Content:
+		pollsMetric.Add("content", 1)
+		p.shadow.update(resp, time.Now())
+		if etag, ok := resp.Option(coap.ETag).([]byte); ok {
+			p.etag = append(p.etag[:0], etag...)
+		} else {
+			p.etag = nil
+		}
+	default:
+		pollsMetric.Add("error", 1)
+		p.etag = nil
+	}
+	onGetResponse(p.res, p.notifications, &coap.Request{Client: p.client.ClientCommander, Msg: resp})
+}
+
+//stop stops the periodic polling
+func (p *resourcePoller) stop() {
+	p.doneOnce.Do(func() {
+		close(p.done)
+	})
+}
@@ -0,0 +1,64 @@
+package service
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//resourceRule selects resources by href or resource type pattern in format "href:<pattern>" or "rt:<pattern>",
+//pattern is matched by path.Match
+type resourceRule struct {
+	href    bool
+	pattern string
+}
+
+func parseResourceRule(key string) (resourceRule, error) {
+	var r resourceRule
+	switch {
+	case strings.HasPrefix(key, "href:"):
+		r = resourceRule{href: true, pattern: strings.TrimPrefix(key, "href:")}
+	case strings.HasPrefix(key, "rt:"):
+		r = resourceRule{pattern: strings.TrimPrefix(key, "rt:")}
+	default:
+		return resourceRule{}, fmt.Errorf("Unsupported resource rule %v", key)
+	}
+	if _, err := path.Match(r.pattern, ""); err != nil {
+		return resourceRule{}, fmt.Errorf("Invalid pattern %v: %v", r.pattern, err)
+	}
+	return r, nil
+}
+
+func (r resourceRule) match(res resources.Resource) bool {
+	if r.href {
+		ok, _ := path.Match(r.pattern, res.Href)
+		return ok
+	}
+	for _, rt := range res.ResourceTypes {
+		if ok, _ := path.Match(r.pattern, rt); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//splitPolicyRules splits comma separated rules of policy to key and value, key is empty for a rule without "="
+func splitPolicyRules(value string) (keys []string, values []string) {
+	for _, rule := range strings.Split(value, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		i := strings.LastIndex(rule, "=")
+		if i < 0 {
+			keys = append(keys, "")
+			values = append(values, rule)
+			continue
+		}
+		keys = append(keys, rule[:i])
+		values = append(values, rule[i+1:])
+	}
+	return keys, values
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/valyala/fasthttp"
+)
+
+//resourceShadow last known representation of an observed resource, ETag is computed from content when the device doesn't provide it.
+//It is updated when the device sends the content, before the content is throttled and forwarded to resource aggregate.
+type resourceShadow struct {
+	content    *resources.Content
+	etag       []byte
+	deviceETag bool // etag was provided by the device, the computed one is unknown to the device
+	updated    time.Time
+	mutex      sync.Mutex
+}
+
+func contentETag(msg coap.Message) (etag []byte, device bool) {
+	if etag, ok := msg.Option(coap.ETag).([]byte); ok && len(etag) > 0 {
+		return append([]byte(nil), etag...), true
+	}
+	sum := sha256.Sum256(msg.Payload())
+	return sum[:8], false
+}
+
+func (s *resourceShadow) update(msg coap.Message, now time.Time) {
+	content := coapMsg2Content(msg)
+	etag, device := contentETag(msg)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.content = content
+	s.etag = etag
+	s.deviceETag = device
+	s.updated = now
+}
+
+//confirm refreshes the shadow when the device confirmed that its content is not changed
+func (s *resourceShadow) confirm(now time.Time) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.content != nil {
+		s.updated = now
+	}
+}
+
+//loadDeviceETag returns ETag of the content provided by the device, nil when the ETag was computed by the gateway
+func (s *resourceShadow) loadDeviceETag() []byte {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.deviceETag {
+		return nil
+	}
+	return s.etag
+}
+
+//load returns the representation when it is not older than maxAge
+func (s *resourceShadow) load(maxAge time.Duration, now time.Time) (content *resources.Content, etag []byte, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.content == nil || now.Sub(s.updated) > maxAge {
+		return nil, nil, false
+	}
+	return s.content, s.etag, true
+}
+
+//loadShadow returns fresh representation of the observed resource
+func (session *Session) loadShadow(deviceID, href string, maxAge time.Duration) (content *resources.Content, etag []byte, ok bool) {
+	session.observedResourcesLock.Lock()
+	defer session.observedResourcesLock.Unlock()
+	for _, value := range session.observedResources[deviceID] {
+		if value.res.Href == href {
+			return value.shadow.load(maxAge, time.Now())
+		}
+	}
+	return nil, nil, false
+}
+
+//loadDeviceETag returns ETag which the device provided for the content of the observed resource
+func (session *Session) loadDeviceETag(deviceID, href string) []byte {
+	session.observedResourcesLock.Lock()
+	defer session.observedResourcesLock.Unlock()
+	for _, value := range session.observedResources[deviceID] {
+		if value.res.Href == href {
+			return value.shadow.loadDeviceETag()
+		}
+	}
+	return nil
+}
+
+//retrieveFromShadow answers retrieve command from the shadow of resource, it returns false when the shadow is missing or stale
+func (server *Server) retrieveFromShadow(session *Session, cmd resourceCommand) (commandResponseEvent, bool) {
+	if cmd.BypassCache {
+		shadowMetric.Add("bypass", 1)
+		return commandResponseEvent{}, false
+	}
+	content, etag, ok := session.loadShadow(cmd.DeviceID, cmd.Href, server.shadowMaxAge)
+	if !ok {
+		shadowMetric.Add("miss", 1)
+		return commandResponseEvent{}, false
+	}
+	shadowMetric.Add("hit", 1)
+	if len(cmd.ETag) > 0 && bytes.Equal(cmd.ETag, etag) {
+		event := newCommandResponseEvent(cmd, fasthttp.StatusNotModified, "")
+		event.ETag = etag
+		return event, true
+	}
+	event := newCommandResponseEvent(cmd, fasthttp.StatusOK, "")
+	event.Content = content
+	event.ETag = etag
+	return event, true
+}
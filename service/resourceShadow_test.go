@@ -0,0 +1,66 @@
+package service
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+type testContentMessage struct {
+	coap.Message
+	payload []byte
+	etag    []byte
+}
+
+func (m testContentMessage) Payload() []byte {
+	return m.payload
+}
+
+func (m testContentMessage) Option(o coap.OptionID) interface{} {
+	switch {
+	case o == coap.ContentFormat:
+		return coap.AppOcfCbor
+	case o == coap.ETag && m.etag != nil:
+		return m.etag
+	}
+	return nil
+}
+
+func TestResourceShadow(t *testing.T) {
+	var s resourceShadow
+	now := time.Now()
+	if _, _, ok := s.load(time.Minute, now); ok {
+		t.Fatalf("empty shadow was loaded")
+	}
+
+	s.update(testContentMessage{payload: []byte("a")}, now)
+	content, etag, ok := s.load(time.Minute, now.Add(time.Second))
+	if !ok || string(content.Data) != "a" || content.CoapContentFormat != int32(coap.AppOcfCbor) || len(etag) == 0 {
+		t.Fatalf("unexpected shadow %v, etag %v", content, etag)
+	}
+	if _, _, ok := s.load(time.Minute, now.Add(2*time.Minute)); ok {
+		t.Fatalf("stale shadow was loaded")
+	}
+
+	s.update(testContentMessage{payload: []byte("a")}, now)
+	if _, etag2, _ := s.load(time.Minute, now); !bytes.Equal(etag, etag2) {
+		t.Fatalf("etag of the same content differs")
+	}
+	s.update(testContentMessage{payload: []byte("b"), etag: []byte{1, 2}}, now)
+	if _, etag, _ := s.load(time.Minute, now); !bytes.Equal(etag, []byte{1, 2}) {
+		t.Fatalf("etag of device was not used: %v", etag)
+	}
+	if etag := s.loadDeviceETag(); !bytes.Equal(etag, []byte{1, 2}) {
+		t.Fatalf("unexpected etag of device %v", etag)
+	}
+	s.confirm(now.Add(2 * time.Minute))
+	if _, _, ok := s.load(time.Minute, now.Add(2*time.Minute)); !ok {
+		t.Fatalf("confirmed shadow is stale")
+	}
+	s.update(testContentMessage{payload: []byte("c")}, now)
+	if etag := s.loadDeviceETag(); etag != nil {
+		t.Fatalf("computed etag is returned as etag of device: %v", etag)
+	}
+}
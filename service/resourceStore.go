@@ -0,0 +1,129 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//resourceStoreRecord is a published or unpublished link in the journal of the store
+type resourceStoreRecord struct {
+	PublisherID string              `json:"publisher"`
+	Link        *resources.Resource `json:"link"`
+	Removed     bool                `json:"removed,omitempty"`
+}
+
+// resourceStore keeps published links of devices independently of sessions, so unpublish by instance ID works after reconnects and restarts
+type resourceStore struct {
+	journal *journal
+	links   map[string]map[string]map[int64]resources.Resource // [publisherID][deviceID][instanceID]
+	mutex   sync.Mutex
+}
+
+func newResourceStore(file string) (*resourceStore, error) {
+	s := &resourceStore{
+		links: make(map[string]map[string]map[int64]resources.Resource),
+	}
+	var err error
+	s.journal, err = openJournal(file, s.replay)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open published links '%v': %v", file, err)
+	}
+	return s, nil
+}
+
+func (s *resourceStore) replay(data []byte) error {
+	var r resourceStoreRecord
+	if err := json.Unmarshal(data, &r); err != nil {
+		return err
+	}
+	if r.Link == nil {
+		return fmt.Errorf("missing link")
+	}
+	if r.Removed {
+		s.removeLocked(r.PublisherID, *r.Link)
+	} else {
+		s.addLocked(r.PublisherID, *r.Link)
+	}
+	return nil
+}
+
+func (s *resourceStore) snapshotLocked() []interface{} {
+	records := make([]interface{}, 0, len(s.links))
+	for publisherID, devices := range s.links {
+		for _, deviceResourcesMap := range devices {
+			for _, res := range deviceResourcesMap {
+				res := res
+				records = append(records, resourceStoreRecord{PublisherID: publisherID, Link: &res})
+			}
+		}
+	}
+	return records
+}
+
+func (s *resourceStore) addLocked(publisherID string, res resources.Resource) {
+	if _, ok := s.links[publisherID]; !ok {
+		s.links[publisherID] = make(map[string]map[int64]resources.Resource)
+	}
+	if _, ok := s.links[publisherID][res.DeviceId]; !ok {
+		s.links[publisherID][res.DeviceId] = make(map[int64]resources.Resource)
+	}
+	s.links[publisherID][res.DeviceId][res.InstanceId] = res
+}
+
+func (s *resourceStore) add(publisherID string, rscs []resources.Resource) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, res := range rscs {
+		s.addLocked(publisherID, res)
+		s.journal.write(resourceStoreRecord{PublisherID: publisherID, Link: &res}, s.snapshotLocked)
+	}
+}
+
+func (s *resourceStore) find(publisherID, deviceID string, instanceIDs []int64, matches []resources.Resource) []resources.Resource {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	deviceResourcesMap, ok := s.links[publisherID][deviceID]
+	if !ok {
+		return matches
+	}
+	if len(instanceIDs) == 0 {
+		for _, res := range deviceResourcesMap {
+			matches = append(matches, res)
+		}
+		return matches
+	}
+	for _, instanceID := range instanceIDs {
+		if res, ok := deviceResourcesMap[instanceID]; ok {
+			matches = append(matches, res)
+		}
+	}
+	return matches
+}
+
+func (s *resourceStore) removeLocked(publisherID string, res resources.Resource) bool {
+	if _, ok := s.links[publisherID][res.DeviceId][res.InstanceId]; !ok {
+		return false
+	}
+	delete(s.links[publisherID][res.DeviceId], res.InstanceId)
+	if len(s.links[publisherID][res.DeviceId]) == 0 {
+		delete(s.links[publisherID], res.DeviceId)
+	}
+	if len(s.links[publisherID]) == 0 {
+		delete(s.links, publisherID)
+	}
+	return true
+}
+
+func (s *resourceStore) remove(publisherID string, rscs []resources.Resource) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, res := range rscs {
+		if s.removeLocked(publisherID, res) {
+			s.journal.write(resourceStoreRecord{PublisherID: publisherID, Link: &res, Removed: true}, s.snapshotLocked)
+		}
+	}
+}
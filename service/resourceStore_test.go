@@ -0,0 +1,47 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+func TestResourceStorePersistence(t *testing.T) {
+	a := resources.Resource{Id: resource2UUID("a", "/a"), DeviceId: "a", Href: "/a", InstanceId: resource2InstanceID("a", "/a")}
+	b := resources.Resource{Id: resource2UUID("a", "/b"), DeviceId: "a", Href: "/b", InstanceId: resource2InstanceID("a", "/b")}
+	testStoreReload(t, func(file string) {
+		s, err := newResourceStore(file)
+		if err != nil {
+			t.Fatalf("cannot create store: %v", err)
+		}
+		s.add("publisher", []resources.Resource{a, b})
+		s.remove("publisher", []resources.Resource{b})
+	}, func(file string) {
+		s, err := newResourceStore(file)
+		if err != nil {
+			t.Fatalf("cannot reload store: %v", err)
+		}
+		rscs := s.find("publisher", "a", nil, nil)
+		if len(rscs) != 1 || rscs[0].Href != "/a" {
+			t.Fatalf("unexpected resources %v after reload", rscs)
+		}
+		if rscs := s.find("publisher", "a", []int64{a.InstanceId}, nil); len(rscs) != 1 {
+			t.Fatalf("cannot find resource by instance ID %v", a.InstanceId)
+		}
+		if rscs := s.find("other", "a", nil, nil); len(rscs) != 0 {
+			t.Fatalf("unexpected resources %v of other publisher", rscs)
+		}
+	})
+}
+
+func TestResource2InstanceID(t *testing.T) {
+	if resource2InstanceID("a", "/a") != resource2InstanceID("a", "/a") {
+		t.Fatalf("instance ID is not stable")
+	}
+	if resource2InstanceID("a", "/a") == resource2InstanceID("a", "/b") {
+		t.Fatalf("instance IDs of different resources are equal")
+	}
+	if ins := resource2InstanceID("a", "/a"); ins < 0 || ins >= 1<<53 {
+		t.Fatalf("instance ID %v is out of range", ins)
+	}
+}
@@ -20,15 +20,83 @@ import (
 
 //config for application
 type config struct {
-	KeepaliveTime     time.Duration `envconfig:"KEEPALIVE_TIME" default:"3600s"`
-	KeepaliveInterval time.Duration `envconfig:"KEEPALIVE_INTERVAL" default:"5s"`
-	KeepaliveRetry    int           `envconfig:"KEEPALIVE_RETRY" default:"5"`
-	Addr              string        `envconfig:"ADDRESS" default:"0.0.0.0:5684"`
-	Net               string        `envconfig:"NETWORK" default:"tcp"`
-	AuthHost          string        `envconfig:"AUTH_HOST"  default:"127.0.0.1"`
-	AuthProtocol      httpProto     `envconfig:"AUTH_PROTOCOL"  default:"http"`
-	ResourceHost      string        `envconfig:"RESOURCE_HOST"  default:"127.0.0.1"`
-	ResourceProtocol  httpProto     `envconfig:"RESOURCE_PROTOCOL"  default:"http"`
+	KeepaliveTime            time.Duration     `envconfig:"KEEPALIVE_TIME" default:"3600s"`
+	KeepaliveInterval        time.Duration     `envconfig:"KEEPALIVE_INTERVAL" default:"5s"`
+	KeepaliveRetry           int               `envconfig:"KEEPALIVE_RETRY" default:"5"`
+	KeepaliveJitter          float64           `envconfig:"KEEPALIVE_JITTER" default:"0.1"`
+	KeepaliveWorkers         int               `envconfig:"KEEPALIVE_WORKERS" default:"16"`
+	KeepaliveOverridesFile   string            `envconfig:"KEEPALIVE_OVERRIDES_FILE"`
+	ReportLinkQuality        bool              `envconfig:"REPORT_LINK_QUALITY" default:"false"`
+	LinkQualityInterval      time.Duration     `envconfig:"LINK_QUALITY_INTERVAL" default:"60s"`
+	Addr                     string            `envconfig:"ADDRESS" default:"0.0.0.0:5684"`
+	Net                      string            `envconfig:"NETWORK" default:"tcp"`
+	AuthHost                 string            `envconfig:"AUTH_HOST"  default:"127.0.0.1"`
+	AuthProtocol             httpProto         `envconfig:"AUTH_PROTOCOL"  default:"http"`
+	ResourceHost             string            `envconfig:"RESOURCE_HOST"  default:"127.0.0.1"`
+	ResourceProtocol         httpProto         `envconfig:"RESOURCE_PROTOCOL"  default:"http"`
+	DataDir                  string            `envconfig:"DATA_DIR"`
+	AdminAddr                string            `envconfig:"ADMIN_ADDRESS"`
+	AdminToken               string            `envconfig:"ADMIN_TOKEN"`
+	RDSelectionPolicy        rdSelectionPolicy `envconfig:"RD_SELECTION_POLICY" default:"0"`
+	ResourceCircuitThreshold int               `envconfig:"RESOURCE_CIRCUIT_THRESHOLD" default:"5"`
+	ResourceCircuitTimeout   time.Duration     `envconfig:"RESOURCE_CIRCUIT_TIMEOUT" default:"30s"`
+	NotificationQueueSize    int               `envconfig:"NOTIFICATION_QUEUE_SIZE" default:"16"`
+	PollingPolicy            pollingPolicy     `envconfig:"POLLING_POLICY" default:"0"`
+	PollingJitter            float64           `envconfig:"POLLING_JITTER" default:"0.1"`
+	ObservationRetryMin      time.Duration     `envconfig:"OBSERVATION_RETRY_MIN" default:"1s"`
+	ObservationRetryMax      time.Duration     `envconfig:"OBSERVATION_RETRY_MAX" default:"5m"`
+	ObservationRetryLimit    int               `envconfig:"OBSERVATION_RETRY_LIMIT" default:"10"`
+	ThrottlingPolicy         throttlingPolicy  `envconfig:"THROTTLING_POLICY" default:"0s/0s"`
+	DeviceRequestTimeout     time.Duration     `envconfig:"DEVICE_REQUEST_TIMEOUT" default:"10s"`
+	DeviceRequestMaxMisses   int               `envconfig:"DEVICE_REQUEST_MAX_MISSES" default:"3"`
+	CommandQueueSize         int               `envconfig:"COMMAND_QUEUE_SIZE" default:"16"`
+	CommandTTL               time.Duration     `envconfig:"COMMAND_TTL" default:"60s"`
+	SpoolMaxEntries          int               `envconfig:"SPOOL_MAX_ENTRIES" default:"10000"`
+	SpoolMaxBytes            int               `envconfig:"SPOOL_MAX_BYTES" default:"16777216"`
+	SpoolReplayInterval      time.Duration     `envconfig:"SPOOL_REPLAY_INTERVAL" default:"5s"`
+	ShadowMaxAge             time.Duration     `envconfig:"SHADOW_MAX_AGE" default:"60s"`
+	SubscriptionHosts        []string          `envconfig:"SUBSCRIPTION_HOSTS"`
+	SubscriptionTimeout      time.Duration     `envconfig:"SUBSCRIPTION_TIMEOUT" default:"10s"`
+	BulkCommandConcurrency   int               `envconfig:"BULK_COMMAND_CONCURRENCY" default:"8"`
+	DesiredStateMaxAttempts  int               `envconfig:"DESIRED_STATE_MAX_ATTEMPTS" default:"3"`
+	DeviceClaimTTL           time.Duration     `envconfig:"DEVICE_CLAIM_TTL" default:"24h"`
+	AutoDiscovery            bool              `envconfig:"AUTO_DISCOVERY" default:"false"`
+	AutoDiscoveryTTL         time.Duration     `envconfig:"AUTO_DISCOVERY_TTL" default:"24h"`
+	PingIntervals            []int             `envconfig:"PING_INTERVALS" default:"1,2,4,8"`
+}
+
+//validate rejects intervals and timeouts which cannot be used, eg. time.Tick doesn't tick for non-positive interval
+func (cfg config) validate() error {
+	for name, d := range map[string]time.Duration{
+		"KEEPALIVE_TIME":           cfg.KeepaliveTime,
+		"KEEPALIVE_INTERVAL":       cfg.KeepaliveInterval,
+		"RESOURCE_CIRCUIT_TIMEOUT": cfg.ResourceCircuitTimeout,
+		"OBSERVATION_RETRY_MIN":    cfg.ObservationRetryMin,
+		"OBSERVATION_RETRY_MAX":    cfg.ObservationRetryMax,
+		"DEVICE_REQUEST_TIMEOUT":   cfg.DeviceRequestTimeout,
+		"COMMAND_TTL":              cfg.CommandTTL,
+		"SPOOL_REPLAY_INTERVAL":    cfg.SpoolReplayInterval,
+		"SUBSCRIPTION_TIMEOUT":     cfg.SubscriptionTimeout,
+		"AUTO_DISCOVERY_TTL":       cfg.AutoDiscoveryTTL,
+	} {
+		if d <= 0 {
+			return fmt.Errorf("invalid %v %v: must be positive", name, d)
+		}
+	}
+	// zero disables the feature
+	for name, d := range map[string]time.Duration{
+		"LINK_QUALITY_INTERVAL": cfg.LinkQualityInterval,
+		"SHADOW_MAX_AGE":        cfg.ShadowMaxAge,
+		"DEVICE_CLAIM_TTL":      cfg.DeviceClaimTTL,
+	} {
+		if d < 0 {
+			return fmt.Errorf("invalid %v %v: must not be negative", name, d)
+		}
+	}
+	if cfg.ObservationRetryMax < cfg.ObservationRetryMin {
+		return fmt.Errorf("invalid OBSERVATION_RETRY_MAX %v: must not be less than OBSERVATION_RETRY_MIN %v", cfg.ObservationRetryMax, cfg.ObservationRetryMin)
+	}
+	return nil
 }
 
 //config for application
@@ -50,9 +118,41 @@ type Server struct {
 	AuthProtocol      string        // http or https
 	ResourceHost      string        // IP/DOMAIN where gateway will create connections for sending commands to resource aggregate
 	ResourceProtocol  string        // http or https
+	DataDir           string        // directory where the gateway persists its state, in-memory only when empty
+	AdminAddr         string        // address of admin server with metrics, disabled when empty
+	AdminToken        string        // bearer token which authorizes requests of admin API, admin API is refused when empty
+
+	clientContainer    *ClientContainer
+	httpClient         *fasthttp.Client
+	resourceStore      *resourceStore
+	rdSelectionPolicy  rdSelectionPolicy
+	resourceCircuit    *circuitBreaker // health of resource aggregate
+	onlineDevices      *onlineDevicesStore
+	commandQueue       *commandQueue // commands of devices which are not connected
+	resourceSpool      *resourceAggregateSpool
+	subscriptions      *subscriptionRegistry
+	deviceTwin         *deviceTwin // desired states of resources
+	keepaliveScheduler *keepaliveScheduler
+	keepaliveOverrides *keepaliveOverrides // keepalive settings per device and per user
+	deviceOwners       *deviceOwners       // users which own devices
 
-	clientContainer *ClientContainer
-	httpClient      *fasthttp.Client
+	notificationQueueSize  int           // maximum number of notifications of a resource waiting for resource aggregate
+	pollingPolicy          pollingPolicy // how often non-observable resources are retrieved
+	pollingJitter          float64       // maximal random prolongation of polling interval as fraction of the interval
+	observationBackoff     observationBackoff
+	throttlingPolicy       throttlingPolicy // throttling of notifications of observed resources
+	deviceRequestTimeout   time.Duration    // time to wait for response of the device
+	deviceRequestMaxMisses int              // number of timeouts in a row after which the device is set as offline
+	spoolReplayInterval    time.Duration    // how often spooled commands are replayed to resource aggregate
+	shadowMaxAge           time.Duration    // how long the shadow of resource answers retrieve commands, zero disables it
+	subscriptionHosts      []string         // hosts where notifications of subscriptions can be posted
+	subscriptionTimeout    time.Duration    // time to wait for subscriber to accept a notification
+	bulkCommandConcurrency int              // maximal number of devices which execute a bulk command at the same time
+	autoDiscovery          bool             // resources of the device are discovered and published by the gateway after sign-in
+	autoDiscoveryTTL       int              // time to live in seconds of resources published by auto-discovery
+	pingIntervals          []int            // intervals in minutes of /oic/ping offered to devices
+	reportLinkQuality      bool             // round trip times of keepalive pings are sent to resource aggregate as a resource of the device
+	linkQualityInterval    time.Duration    // minimal interval between reports of connectivity quality of a device
 }
 
 func setupTLS() (*tls.Config, error) {
@@ -156,12 +256,23 @@ func (a *httpProto) Decode(value string) error {
 	}
 }
 
+//dataPath returns path of the state stored in the data directory, empty when the gateway keeps state only in memory
+func (server *Server) dataPath(name string) string {
+	if server.DataDir == "" {
+		return ""
+	}
+	return filepath.Join(server.DataDir, name)
+}
+
 //NewServer setup coap gateway
 func NewServer() (*Server, error) {
 	var cfg config
 	if err := envconfig.Process(os.Args[0], &cfg); err != nil {
 		return nil, err
 	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
 
 	s := Server{
 		keepaliveTime:     cfg.KeepaliveTime,
@@ -173,13 +284,75 @@ func NewServer() (*Server, error) {
 		AuthProtocol:      string(cfg.AuthProtocol),
 		ResourceHost:      cfg.ResourceHost,
 		ResourceProtocol:  string(cfg.ResourceProtocol),
+		DataDir:           cfg.DataDir,
+		AdminAddr:         cfg.AdminAddr,
+		AdminToken:        cfg.AdminToken,
+
+		clientContainer:    newClientContainer(),
+		httpClient:         &fasthttp.Client{},
+		rdSelectionPolicy:  cfg.RDSelectionPolicy,
+		resourceCircuit:    newCircuitBreaker("resource aggregate", cfg.ResourceCircuitThreshold, cfg.ResourceCircuitTimeout),
+		subscriptions:      newSubscriptionRegistry(),
+		keepaliveScheduler: newKeepaliveScheduler(cfg.KeepaliveJitter, cfg.KeepaliveWorkers),
 
-		clientContainer: &ClientContainer{sessions: make(map[string]*Session)},
-		httpClient:      &fasthttp.Client{},
+		notificationQueueSize: cfg.NotificationQueueSize,
+		pollingPolicy:         cfg.PollingPolicy,
+		pollingJitter:         cfg.PollingJitter,
+		observationBackoff: observationBackoff{
+			min:   cfg.ObservationRetryMin,
+			max:   cfg.ObservationRetryMax,
+			limit: cfg.ObservationRetryLimit,
+		},
+		throttlingPolicy:       cfg.ThrottlingPolicy,
+		deviceRequestTimeout:   cfg.DeviceRequestTimeout,
+		deviceRequestMaxMisses: cfg.DeviceRequestMaxMisses,
+		spoolReplayInterval:    cfg.SpoolReplayInterval,
+		shadowMaxAge:           cfg.ShadowMaxAge,
+		subscriptionHosts:      cfg.SubscriptionHosts,
+		subscriptionTimeout:    cfg.SubscriptionTimeout,
+		bulkCommandConcurrency: cfg.BulkCommandConcurrency,
+		autoDiscovery:          cfg.AutoDiscovery,
+		autoDiscoveryTTL:       int(cfg.AutoDiscoveryTTL / time.Second),
+		pingIntervals:          cfg.PingIntervals,
+		reportLinkQuality:      cfg.ReportLinkQuality,
+		linkQualityInterval:    cfg.LinkQualityInterval,
+	}
+
+	var err error
+	s.resourceStore, err = newResourceStore(s.dataPath("links.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	s.deviceOwners, err = newDeviceOwners(s.dataPath("owners.jsonl"), cfg.DeviceClaimTTL)
+	if err != nil {
+		return nil, err
+	}
+	s.onlineDevices, err = newOnlineDevicesStore(s.dataPath("online.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	s.commandQueue, err = newCommandQueue(s.dataPath("commands.jsonl"), cfg.CommandQueueSize, cfg.CommandTTL)
+	if err != nil {
+		return nil, err
+	}
+	s.resourceSpool, err = newResourceAggregateSpool(s.dataPath("spool.jsonl"), cfg.SpoolMaxEntries, cfg.SpoolMaxBytes)
+	if err != nil {
+		return nil, err
+	}
+	s.deviceTwin, err = newDeviceTwin(s.dataPath("desired.jsonl"), cfg.DesiredStateMaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	keepaliveOverridesFile := cfg.KeepaliveOverridesFile
+	if keepaliveOverridesFile == "" {
+		keepaliveOverridesFile = s.dataPath("keepalive.jsonl")
+	}
+	s.keepaliveOverrides, err = newKeepaliveOverrides(keepaliveOverridesFile)
+	if err != nil {
+		return nil, err
 	}
 
 	if strings.Contains(s.Net, "tls") {
-		var err error
 		s.TLSConfig, err = setupTLS()
 		if err != nil {
 			return nil, err
@@ -191,6 +364,9 @@ func NewServer() (*Server, error) {
 
 func validateCommandCode(s coap.ResponseWriter, req *coap.Request, server *Server, fnc func(s coap.ResponseWriter, req *coap.Request, server *Server)) {
 	decodeMsgToDebug(req.Msg, "MESSAGE_FROM_CLIENT")
+	if session := server.clientContainer.find(req.Client.RemoteAddr().String()); session != nil {
+		session.keepalive.touch()
+	}
 	switch req.Msg.Code() {
 	case coap.POST, coap.DELETE, coap.PUT, coap.GET:
 		fnc(s, req, server)
@@ -221,6 +397,12 @@ func (server *Server) NewCoapServer() *coap.Server {
 	mux.Handle(signIn, coap.HandlerFunc(func(s coap.ResponseWriter, req *coap.Request) {
 		validateCommandCode(s, req, server, signInHandler)
 	}))
+	mux.Handle(resourceDiscovery, coap.HandlerFunc(func(s coap.ResponseWriter, req *coap.Request) {
+		validateCommandCode(s, req, server, resourceDiscoveryHandler)
+	}))
+	mux.Handle(pingURI, coap.HandlerFunc(func(s coap.ResponseWriter, req *coap.Request) {
+		validateCommandCode(s, req, server, pingHandler)
+	}))
 
 	return &coap.Server{
 		Net:       server.Net,
@@ -238,6 +420,12 @@ func (server *Server) NewCoapServer() *coap.Server {
 
 //ListenAndServe starts a coapgateway on the configured address in *Server.
 func (server *Server) ListenAndServe() error {
+	server.serveAdmin()
+	go server.reconcileDeviceStatus()
+	go server.expireQueuedCommands(time.Second)
+	go server.replaySpoolPeriodically(server.spoolReplayInterval)
+	go server.keepaliveScheduler.run()
+	defer server.keepaliveScheduler.stop()
 	return server.NewCoapServer().ListenAndServe()
 }
 
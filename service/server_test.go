@@ -265,3 +265,39 @@ XVqB37LdYPcCIQDiqvcbW0aOfVcvMDVs3r1HavgKuTIHgJ9uzSOAAF17vg==
 -----END CERTIFICATE-----
 `)
 )
+
+func TestConfigValidate(t *testing.T) {
+	valid := config{
+		KeepaliveTime:          time.Hour,
+		KeepaliveInterval:      5 * time.Second,
+		ResourceCircuitTimeout: 30 * time.Second,
+		ObservationRetryMin:    time.Second,
+		ObservationRetryMax:    time.Minute,
+		DeviceRequestTimeout:   10 * time.Second,
+		CommandTTL:             time.Minute,
+		SpoolReplayInterval:    5 * time.Second,
+		SubscriptionTimeout:    10 * time.Second,
+		AutoDiscoveryTTL:       time.Hour,
+	}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tbl := []struct {
+		name   string
+		modify func(cfg *config)
+	}{
+		{"ZeroSpoolReplayInterval", func(cfg *config) { cfg.SpoolReplayInterval = 0 }},
+		{"NegativeKeepaliveTime", func(cfg *config) { cfg.KeepaliveTime = -time.Second }},
+		{"NegativeShadowMaxAge", func(cfg *config) { cfg.ShadowMaxAge = -time.Second }},
+		{"ObservationRetryMaxLessThanMin", func(cfg *config) { cfg.ObservationRetryMax = time.Millisecond }},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := valid
+			tt.modify(&cfg)
+			if err := cfg.validate(); err == nil {
+				t.Fatalf("config must be invalid")
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package service
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/go-ocf/authorization/protobuf/auth"
 	coap "github.com/go-ocf/go-coap"
@@ -11,34 +13,49 @@ import (
 )
 
 type observedResource struct {
-	res         resources.Resource
-	observation *coap.Observation
+	res           resources.Resource
+	ttl           int
+	observer      *resourceObserver
+	poller        *resourcePoller
+	notifications *notificationQueue
+	shadow        *resourceShadow
+	subscribers   *resourceSubscribers
 }
 
 //Session a setup of connection
 type Session struct {
 	server    *Server
 	client    *coap.ClientCommander
+	device    *deviceClient // requests to the device with the device request timeout
 	keepalive *Keepalive
 
 	observedResources     map[string]map[int64]observedResource // [deviceID][instanceID]
 	observedResourcesLock sync.Mutex
 	authContext           resourcesCommands.AuthorizationContext
+	online                bool                 // status of the signed-in device sent to resource aggregate
+	linkQuality           *linkQualityReporter // reports of connectivity quality of the signed-in device, nil when they are disabled
 	authContextLock       sync.Mutex
 }
 
 //NewSession create and initialize session
 func newSession(server *Server, client *coap.ClientCommander) *Session {
 	log.Infof("Close session %v", client.RemoteAddr())
-	return &Session{
+	session := &Session{
 		server:            server,
 		client:            client,
+		device:            newDeviceClient(client, server.deviceRequestTimeout, server.deviceRequestMaxMisses),
 		keepalive:         NewKeepalive(server, client),
 		observedResources: make(map[string]map[int64]observedResource),
 	}
+	session.device.activity = session.keepalive.touch
+	if server.reportLinkQuality {
+		session.linkQuality = newLinkQualityReporter(server.linkQualityInterval)
+		session.keepalive.setPingCallback(session.reportLinkQuality)
+	}
+	return session
 }
 
-func (session *Session) observeResource(res resources.Resource) error {
+func (session *Session) observeResource(res resources.Resource, ttl int, linkThrottling notificationThrottling) error {
 	session.observedResourcesLock.Lock()
 	defer session.observedResourcesLock.Unlock()
 	if _, ok := session.observedResources[res.DeviceId]; !ok {
@@ -48,70 +65,76 @@ func (session *Session) observeResource(res resources.Resource) error {
 		log.Warnf("Resource ocf://%v/%v are already published", res.DeviceId, res.Href)
 		return nil
 	}
-	return session.addObservedResourceLocked(res)
+	return session.addObservedResourceLocked(res, ttl, linkThrottling)
 }
 
-func (session *Session) addObservedResourceLocked(res resources.Resource) error {
-	var observation *coap.Observation
+func (session *Session) addObservedResourceLocked(res resources.Resource, ttl int, linkThrottling notificationThrottling) error {
+	var observer *resourceObserver
+	var poller *resourcePoller
 	obs := isObservable(res)
 	log.Infof("add published resource ocf://%v/%v, observable: %v", res.DeviceId, res.Href, obs)
+	shadow := &resourceShadow{}
+	subscribers := newResourceSubscribers()
+	notifications := newNotificationQueue(res.DeviceId+res.Href, session.server.notificationQueueSize, func(msg coap.Message) {
+		session.keepalive.touch()
+		content := coapMsg2Content(msg)
+		subscribers.publish(msg)
+		session.notifyResourceChanged(res, content)
+		// the queue forwards notifications of the resource one by one, so the resource is reconciled once at a time
+		session.server.reconcileDesiredState(session, res.DeviceId, res.Href, content)
+	})
 	if obs {
-		obs, err := session.client.Observe(res.Href, onObserveNotification)
-		if err != nil {
-			log.Errorf("Cannot observe ocf://%v/%v", res.DeviceId, res.Href)
-		} else {
-			observation = obs
-		}
+		throttling := session.server.throttlingPolicy.throttling(res, linkThrottling)
+		observer = newResourceObserver(session.device, res, session.server.observationBackoff, throttling, notifications, shadow)
 	} else {
-		go func(client *coap.ClientCommander, deviceId string, href string) {
-			resp, err := client.Get(href)
-			if err != nil {
-				log.Errorf("Cannot get ocf://%v/%v", deviceId, href)
-				return
-			}
-			onGetResponse(&coap.Request{Client: client, Msg: resp})
-		}(session.client, res.DeviceId, res.Href)
+		poller = newResourcePoller(session.device, res, session.server.pollingPolicy.interval(res), session.server.pollingJitter, notifications, shadow)
 	}
-	session.observedResources[res.DeviceId][res.InstanceId] = observedResource{res: res, observation: observation}
+	session.observedResources[res.DeviceId][res.InstanceId] = observedResource{res: res, ttl: ttl, observer: observer, poller: poller, notifications: notifications, shadow: shadow, subscribers: subscribers}
 	return nil
 }
 
-func (session *Session) getObservedResources(deviceID string, instanceIDs []int64, matches []resources.Resource) []resources.Resource {
+func (session *Session) findObservedResources(filter resourceFilter, devices map[string]*wkRd) {
 	session.observedResourcesLock.Lock()
 	defer session.observedResourcesLock.Unlock()
 
-	getAllDeviceIDMatches := len(instanceIDs) == 0
-
-	if deviceResourcesMap, ok := session.observedResources[deviceID]; ok {
-		if getAllDeviceIDMatches {
-			for _, value := range deviceResourcesMap {
-				matches = append(matches, value.res)
+	for deviceID, deviceResourcesMap := range session.observedResources {
+		if !filter.matchDeviceID(deviceID) {
+			continue
+		}
+		for _, value := range deviceResourcesMap {
+			if !filter.match(value.res) {
+				continue
 			}
-		} else {
-			for _, instanceID := range instanceIDs {
-				if resource, ok := deviceResourcesMap[instanceID]; ok {
-					matches = append(matches, resource.res)
-				}
+			device, ok := devices[deviceID]
+			if !ok {
+				device = &wkRd{DeviceID: deviceID}
+				devices[deviceID] = device
+			}
+			device.Links = append(device.Links, value.res)
+			if value.ttl > device.TimeToLive {
+				device.TimeToLive = value.ttl
 			}
 		}
 	}
-
-	return matches
 }
 
 func (session *Session) unobserveResourceLocked(deviceID string, instanceID int64, deleteResource bool) {
+	observed, ok := session.observedResources[deviceID][instanceID]
+	if !ok {
+		return
+	}
 	log.Infof("remove published resource ocf://%v/%v", deviceID, instanceID)
 
-	obs := session.observedResources[deviceID][instanceID].observation
-	if obs != nil {
-		log.Infof("cancel observation of ocf://%v/%v", deviceID, instanceID)
-		err := obs.Cancel()
-		if err != nil {
-			log.Errorf("Cannot cancel observation ocf//%v/%v", deviceID, instanceID)
-		}
+	if observed.observer != nil {
+		observed.observer.stop()
+	}
+	if observed.poller != nil {
+		observed.poller.stop()
 	}
+	session.server.subscriptions.remove(observed.subscribers.closeAll()...)
 
 	if deleteResource {
+		observed.notifications.close()
 		delete(session.observedResources[deviceID], instanceID)
 		if len(session.observedResources[deviceID]) == 0 {
 			delete(session.observedResources, deviceID)
@@ -124,10 +147,10 @@ func (session *Session) unobserveResources(rscs []resources.Resource, rscsUnpubl
 	defer session.observedResourcesLock.Unlock()
 
 	for _, resource := range rscs {
-		if _, ok := session.observedResources[resource.DeviceId]; ok {
+		if _, ok := session.observedResources[resource.DeviceId][resource.InstanceId]; ok {
 			session.unobserveResourceLocked(resource.DeviceId, resource.InstanceId, rscsUnpublished[resource.Id])
 		} else {
-			log.Errorf("Cannot unobserve resource %v: resource not found", resource.Id)
+			log.Infof("Resource %v is not observed by session %v", resource.Id, session.client.RemoteAddr())
 		}
 	}
 }
@@ -136,12 +159,100 @@ func (session *Session) close() {
 	log.Infof("Close session %v", session.client.RemoteAddr())
 	session.keepalive.Done()
 	session.observedResourcesLock.Lock()
-	defer session.observedResourcesLock.Unlock()
 	for deviceID, instanceIDs := range session.observedResources {
 		for instanceID := range instanceIDs {
 			session.unobserveResourceLocked(deviceID, instanceID, true)
 		}
 	}
+	session.observedResourcesLock.Unlock()
+	session.updateStatus(false)
+}
+
+//updateStatus sends status of the signed-in device to resource aggregate when it changed
+func (session *Session) updateStatus(online bool) {
+	session.authContextLock.Lock()
+	authContext := session.authContext
+	changed := authContext.DeviceId != "" && session.online != online
+	session.online = online
+	session.authContextLock.Unlock()
+	if !changed {
+		return
+	}
+	if err := updateDeviceStatus(session.server, authContext, online); err != nil {
+		log.Errorf("%v", err)
+	}
+}
+
+//signOut sets the signed-in device as offline and clears the authorization context of the session
+func (session *Session) signOut() {
+	session.updateStatus(false)
+	session.server.clientContainer.signOut(session)
+}
+
+//reportLinkQuality sends connectivity quality of the signed-in device to resource aggregate in background when it changed materially
+func (session *Session) reportLinkQuality(info linkQualityInfo) {
+	authContext := session.loadAuthorizationContext()
+	if authContext.DeviceId == "" {
+		return
+	}
+	publish, report := session.linkQuality.begin(info, time.Now())
+	if !report {
+		return
+	}
+	go func() {
+		err := reportLinkQuality(session.server, authContext, publish, info)
+		if err != nil {
+			log.Errorf("%v", err)
+		}
+		session.linkQuality.end(info, time.Now(), err)
+	}()
+}
+
+func (session *Session) info() sessionInfo {
+	authContext := session.loadAuthorizationContext()
+	outstanding, offline := session.device.health()
+	info := sessionInfo{
+		RemoteAddr:  session.client.RemoteAddr().String(),
+		DeviceID:    authContext.DeviceId,
+		UserID:      authContext.UserId,
+		Offline:     offline,
+		Outstanding: outstanding,
+		LinkQuality: session.keepalive.quality.info(),
+		Resources:   make([]resourceInfo, 0, 16),
+	}
+
+	session.observedResourcesLock.Lock()
+	defer session.observedResourcesLock.Unlock()
+	for _, deviceResourcesMap := range session.observedResources {
+		for _, value := range deviceResourcesMap {
+			r := resourceInfo{
+				DeviceID:    value.res.DeviceId,
+				Href:        value.res.Href,
+				InstanceID:  value.res.InstanceId,
+				Observable:  value.observer != nil,
+				Subscribers: value.subscribers.count(),
+			}
+			if value.observer != nil {
+				state, retries, lastError := value.observer.health()
+				r.Observation = &observationInfo{State: state, Retries: retries, LastError: lastError}
+			}
+			info.Resources = append(info.Resources, r)
+		}
+	}
+	sort.Slice(info.Resources, func(i, j int) bool {
+		if info.Resources[i].DeviceID == info.Resources[j].DeviceID {
+			return info.Resources[i].Href < info.Resources[j].Href
+		}
+		return info.Resources[i].DeviceID < info.Resources[j].DeviceID
+	})
+	return info
+}
+
+func (session *Session) notifyResourceChanged(res resources.Resource, content *resources.Content) {
+	err := notifyResourceChanged(session.server, session.loadAuthorizationContext(), res, content)
+	if err != nil {
+		log.Errorf("%v", err)
+	}
 }
 
 func (session *Session) storeAuthorizationContext(authContext resourcesCommands.AuthorizationContext) {
@@ -4,12 +4,25 @@ import (
 	"sync"
 
 	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/resources/protobuf/resources/commands"
 )
 
 //ClientContainer client <-> server connections
 type ClientContainer struct {
-	sessions map[string]*Session
-	mutex    sync.Mutex
+	sessions       map[string]*Session
+	devices        map[string]*Session          // [deviceID] session which signed in or published the device
+	sessionDevices map[*Session]map[string]bool // devices indexed by the session
+	users          map[string]map[*Session]bool // [userID] signed-in sessions of the user
+	mutex          sync.Mutex
+}
+
+func newClientContainer() *ClientContainer {
+	return &ClientContainer{
+		sessions:       make(map[string]*Session),
+		devices:        make(map[string]*Session),
+		sessionDevices: make(map[*Session]map[string]bool),
+		users:          make(map[string]map[*Session]bool),
+	}
 }
 
 func (c *ClientContainer) add(server *Server, client *coap.ClientCommander) {
@@ -27,9 +40,106 @@ func (c *ClientContainer) find(remoteAddr string) *Session {
 	return nil
 }
 
-func (c *ClientContainer) remove(s *coap.ClientCommander) {
+func (c *ClientContainer) indexDeviceLocked(deviceID string, session *Session) {
+	if previous, ok := c.devices[deviceID]; ok && previous != session {
+		c.unindexDeviceLocked(deviceID, previous)
+	}
+	c.devices[deviceID] = session
+	if _, ok := c.sessionDevices[session]; !ok {
+		c.sessionDevices[session] = make(map[string]bool)
+	}
+	c.sessionDevices[session][deviceID] = true
+}
+
+func (c *ClientContainer) unindexDeviceLocked(deviceID string, session *Session) {
+	if c.devices[deviceID] == session {
+		delete(c.devices, deviceID)
+	}
+	delete(c.sessionDevices[session], deviceID)
+	if len(c.sessionDevices[session]) == 0 {
+		delete(c.sessionDevices, session)
+	}
+}
+
+func (c *ClientContainer) unindexUserLocked(userID string, session *Session) {
+	delete(c.users[userID], session)
+	if len(c.users[userID]) == 0 {
+		delete(c.users, userID)
+	}
+}
+
+//indexDevice indexes device published by the session, the caller checks the session may publish the device
+func (c *ClientContainer) indexDevice(deviceID string, session *Session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.indexDeviceLocked(deviceID, session)
+}
+
+//signIn stores the authorization context of the session and indexes the session by its device and user
+func (c *ClientContainer) signIn(session *Session, authContext commands.AuthorizationContext) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if previous := session.loadAuthorizationContext(); previous.UserId != authContext.UserId {
+		c.unindexUserLocked(previous.UserId, session)
+	}
+	session.storeAuthorizationContext(authContext)
+	c.indexDeviceLocked(authContext.DeviceId, session)
+	if _, ok := c.users[authContext.UserId]; !ok {
+		c.users[authContext.UserId] = make(map[*Session]bool)
+	}
+	c.users[authContext.UserId][session] = true
+}
+
+//signOut clears the authorization context of the session and removes the session from indexes of its device and user
+func (c *ClientContainer) signOut(session *Session) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	authContext := session.loadAuthorizationContext()
+	session.storeAuthorizationContext(commands.AuthorizationContext{})
+	if authContext.DeviceId != "" {
+		c.unindexDeviceLocked(authContext.DeviceId, session)
+	}
+	c.unindexUserLocked(authContext.UserId, session)
+}
+
+func (c *ClientContainer) findByDeviceID(deviceID string) *Session {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if session, ok := c.devices[deviceID]; ok {
+		return session
+	}
+	return nil
+}
+
+func (c *ClientContainer) findByUserID(userID string) []*Session {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	sessions := make([]*Session, 0, len(c.users[userID]))
+	for session := range c.users[userID] {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (c *ClientContainer) list() []*Session {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
-	c.sessions[s.RemoteAddr().String()].close()
+	sessions := make([]*Session, 0, len(c.sessions))
+	for _, session := range c.sessions {
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+func (c *ClientContainer) remove(s *coap.ClientCommander) {
+	c.mutex.Lock()
+	session := c.sessions[s.RemoteAddr().String()]
 	delete(c.sessions, s.RemoteAddr().String())
+	for deviceID := range c.sessionDevices[session] {
+		c.unindexDeviceLocked(deviceID, session)
+	}
+	c.unindexUserLocked(session.loadAuthorizationContext().UserId, session)
+	c.mutex.Unlock()
+	//session is closed outside of the lock, it sends offline status to resource aggregate
+	session.close()
 }
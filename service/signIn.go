@@ -29,18 +29,32 @@ func validateSignIn(signIn auth.SignInRequest) error {
 	return nil
 }
 
+//signInLogin distinguishes sign-out, it is a sign-in request with login set to false
+type signInLogin struct {
+	Login *bool `json:"login"`
+}
+
+func isSignOut(payload []byte) bool {
+	var l signInLogin
+	if err := codec.NewDecoderBytes(payload, new(codec.CborHandle)).Decode(&l); err != nil {
+		return false
+	}
+	return l.Login != nil && !*l.Login
+}
+
 func postSignInURI(server *Server) string {
 	return server.AuthProtocol + "://" + server.AuthHost + uri.SignIn
 }
 
-func storeSessionInformation(s coap.ResponseWriter, req *coap.Request, server *Server, signIn auth.SignInRequest) error {
+func storeSessionInformation(s coap.ResponseWriter, req *coap.Request, server *Server, signIn auth.SignInRequest) (*Session, error) {
 	session := server.clientContainer.find(req.Client.RemoteAddr().String())
 	if session == nil {
-		return errors.New("Cannot find session")
+		return nil, errors.New("Cannot find session")
 	}
 
-	session.storeAuthorizationContext(signInRequest2AuthorizationContext(signIn))
-	return nil
+	server.clientContainer.signIn(session, signInRequest2AuthorizationContext(signIn))
+	server.deviceOwners.set(signIn.DeviceId, signIn.UserId)
+	return session, nil
 }
 
 // https://github.com/openconnectivityfoundation/security/blob/master/oic.r.session.raml#L27
@@ -85,7 +99,15 @@ func signInPostHandler(s coap.ResponseWriter, req *coap.Request, server *Server)
 		return
 	}
 
-	err = storeSessionInformation(s, req, server, signIn)
+	if isSignOut(req.Msg.Payload()) {
+		sendResponse(s, req.Client, code, out.Bytes())
+		if session := server.clientContainer.find(req.Client.RemoteAddr().String()); session != nil {
+			session.signOut()
+		}
+		return
+	}
+
+	session, err := storeSessionInformation(s, req, server, signIn)
 	if err != nil {
 		log.Errorf("Cannot store session information for client: %v", err)
 		sendResponse(s, req.Client, coap.BadRequest, nil)
@@ -93,6 +115,11 @@ func signInPostHandler(s coap.ResponseWriter, req *coap.Request, server *Server)
 	}
 
 	sendResponse(s, req.Client, code, out.Bytes())
+	session.updateStatus(true)
+	server.applyKeepaliveOverrides(session)
+	if server.autoDiscovery {
+		go server.discoverDeviceResources(session)
+	}
 }
 
 // Sign-in
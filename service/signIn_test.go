@@ -52,3 +52,9 @@ func TestSignInPostHandler(t *testing.T) {
 		t.Run(test.name, tf)
 	}
 }
+
+//testSignInDevice signs in the connection as the device of user 0, the gateway must use the auth server of testCreateAuthServer
+func testSignInDevice(t *testing.T, co *coap.ClientConn, deviceID string) {
+	test := testEl{"SignIn", input{coap.POST, `{"di": "` + deviceID + `", "uid":"0", "accesstoken":"123" }`, nil}, output{coap.Changed, `{"expiresin":1}`, nil}}
+	testPostHandler(t, signIn, test, co)
+}
@@ -0,0 +1,273 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	coap "github.com/go-ocf/go-coap"
+	"github.com/go-ocf/kit/log"
+	"github.com/go-ocf/resources/protobuf/resources"
+	"github.com/valyala/fasthttp"
+)
+
+const adminSubscriptions = "/api/v1/subscriptions"
+
+//resourceSubscription delivers notifications of an observed resource to a cloud subscriber through its own queue
+type resourceSubscription struct {
+	ID       string `json:"id"`
+	UserID   string `json:"uid"` // owner of the device who subscribed
+	DeviceID string `json:"di"`
+	Href     string `json:"href"`
+	URI      string `json:"uri"` // where notifications are posted, its host must be allowed by configuration
+
+	queue *notificationQueue
+}
+
+//subscriptionEvent notification delivered to a subscriber
+type subscriptionEvent struct {
+	SubscriptionID string             `json:"subscriptionId"`
+	DeviceID       string             `json:"di"`
+	Href           string             `json:"href"`
+	Content        *resources.Content `json:"content"`
+}
+
+//resourceSubscribers subscriptions attached to the one observation of a resource, the observation is kept by the published link regardless of subscriptions
+type resourceSubscribers struct {
+	subscriptions map[string]*resourceSubscription
+	mutex         sync.Mutex
+}
+
+func newResourceSubscribers() *resourceSubscribers {
+	return &resourceSubscribers{subscriptions: make(map[string]*resourceSubscription)}
+}
+
+func (s *resourceSubscribers) attach(sub *resourceSubscription) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.subscriptions[sub.ID] = sub
+	return len(s.subscriptions)
+}
+
+func (s *resourceSubscribers) detach(id string) (count int, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sub, ok := s.subscriptions[id]
+	if !ok {
+		return len(s.subscriptions), false
+	}
+	sub.queue.close()
+	delete(s.subscriptions, id)
+	return len(s.subscriptions), true
+}
+
+func (s *resourceSubscribers) find(id string) *resourceSubscription {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.subscriptions[id]
+}
+
+func (s *resourceSubscribers) count() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.subscriptions)
+}
+
+//publish pushes the notification to queues of all subscribers
+func (s *resourceSubscribers) publish(msg coap.Message) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, sub := range s.subscriptions {
+		sub.queue.push(msg)
+	}
+}
+
+//closeAll detaches all subscriptions, it is called when the observation ends
+func (s *resourceSubscribers) closeAll() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ids := make([]string, 0, len(s.subscriptions))
+	for id, sub := range s.subscriptions {
+		sub.queue.close()
+		ids = append(ids, id)
+	}
+	s.subscriptions = make(map[string]*resourceSubscription)
+	return ids
+}
+
+//subscriptionRegistry finds session of the subscription
+type subscriptionRegistry struct {
+	sessions map[string]*Session // [subscriptionID]
+	mutex    sync.Mutex
+}
+
+func newSubscriptionRegistry() *subscriptionRegistry {
+	return &subscriptionRegistry{sessions: make(map[string]*Session)}
+}
+
+func (r *subscriptionRegistry) add(id string, session *Session) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.sessions[id] = session
+}
+
+func (r *subscriptionRegistry) remove(ids ...string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for _, id := range ids {
+		delete(r.sessions, id)
+	}
+}
+
+func (r *subscriptionRegistry) find(id string) *Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.sessions[id]
+}
+
+func newSubscriptionID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+//subscribe attaches the subscription to the observation of the resource
+func (session *Session) subscribe(sub *resourceSubscription) error {
+	session.observedResourcesLock.Lock()
+	defer session.observedResourcesLock.Unlock()
+	for _, value := range session.observedResources[sub.DeviceID] {
+		if value.res.Href != sub.Href {
+			continue
+		}
+		client, timeout := session.server.httpClient, session.server.subscriptionTimeout
+		sub.queue = newNotificationQueue(sub.ID, session.server.notificationQueueSize, func(msg coap.Message) {
+			event := subscriptionEvent{SubscriptionID: sub.ID, DeviceID: sub.DeviceID, Href: sub.Href, Content: coapMsg2Content(msg)}
+			if err := postJSON(client, sub.URI, event, timeout); err != nil {
+				log.Errorf("Cannot deliver notification of ocf://%v%v to subscription %v: %v", sub.DeviceID, sub.Href, sub.ID, err)
+				subscriptionsMetric.Add("failed", 1)
+				return
+			}
+			subscriptionsMetric.Add("delivered", 1)
+		})
+		count := value.subscribers.attach(sub)
+		session.server.subscriptions.add(sub.ID, session)
+		log.Infof("Subscription %v attached to observation of ocf://%v%v, subscriptions: %v", sub.ID, sub.DeviceID, sub.Href, count)
+		return nil
+	}
+	return fmt.Errorf("resource ocf://%v%v is not published", sub.DeviceID, sub.Href)
+}
+
+//unsubscribe detaches the subscription of the user from the observation of the resource
+func (session *Session) unsubscribe(id, userID string) bool {
+	session.observedResourcesLock.Lock()
+	defer session.observedResourcesLock.Unlock()
+	for _, deviceResourcesMap := range session.observedResources {
+		for _, value := range deviceResourcesMap {
+			if sub := value.subscribers.find(id); sub == nil || sub.UserID != userID {
+				continue
+			}
+			if count, ok := value.subscribers.detach(id); ok {
+				session.server.subscriptions.remove(id)
+				log.Infof("Subscription %v detached from observation of ocf://%v%v, subscriptions: %v", id, value.res.DeviceId, value.res.Href, count)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//subscriptionURIAllowed returns error when notifications cannot be posted to the URI
+func (server *Server) subscriptionURIAllowed(rawURI string) error {
+	u, err := url.Parse(rawURI)
+	if err != nil {
+		return fmt.Errorf("invalid uri: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme of uri '%v'", u.Scheme)
+	}
+	if !containsString(server.subscriptionHosts, u.Host) && !containsString(server.subscriptionHosts, u.Hostname()) {
+		return fmt.Errorf("host '%v' of uri is not allowed", u.Host)
+	}
+	return nil
+}
+
+func (server *Server) adminSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		var sub resourceSubscription
+		if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if sub.UserID == "" || sub.DeviceID == "" || sub.Href == "" || sub.URI == "" {
+			http.Error(w, "user id, device id, href and uri are required", http.StatusBadRequest)
+			return
+		}
+		if server.deviceOwners.owner(sub.DeviceID) != sub.UserID {
+			http.Error(w, "device is not owned by the user", http.StatusForbidden)
+			return
+		}
+		if err := server.subscriptionURIAllowed(sub.URI); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+		session := server.clientContainer.findByDeviceID(sub.DeviceID)
+		if session == nil {
+			http.Error(w, "device is not connected", http.StatusNotFound)
+			return
+		}
+		id, err := newSubscriptionID()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sub.ID = id
+		if err := session.subscribe(&sub); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		subscriptionsMetric.Add("subscribed", 1)
+		writeJSON(w, http.StatusCreated, sub)
+	case http.MethodDelete:
+		id := r.URL.Query().Get("id")
+		session := server.subscriptions.find(id)
+		if session == nil || !session.unsubscribe(id, r.URL.Query().Get("uid")) {
+			http.Error(w, "subscription not found", http.StatusNotFound)
+			return
+		}
+		subscriptionsMetric.Add("unsubscribed", 1)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//postJSON posts the value encoded in JSON to the URI, it fails when the response doesn't arrive within the timeout
+func postJSON(client *fasthttp.Client, uri string, v interface{}, timeout time.Duration) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	resp := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(resp)
+	req.SetRequestURI(uri)
+	req.Header.SetMethod(http.MethodPost)
+	req.Header.SetContentType("application/json")
+	req.SetBody(body)
+	if err := client.DoTimeout(req, resp, timeout); err != nil {
+		return err
+	}
+	if resp.StatusCode() >= fasthttp.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status code %v", resp.StatusCode())
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	coap "github.com/go-ocf/go-coap"
+)
+
+func TestResourceSubscribers(t *testing.T) {
+	s := newResourceSubscribers()
+	delivered := make(map[string][]int)
+	var deliveredLock sync.Mutex
+	newSubscription := func(id string) *resourceSubscription {
+		return &resourceSubscription{ID: id, queue: newNotificationQueue(id, 4, func(msg coap.Message) {
+			deliveredLock.Lock()
+			defer deliveredLock.Unlock()
+			delivered[id] = append(delivered[id], msg.(testMessage).seq)
+		})}
+	}
+	a, b := newSubscription("a"), newSubscription("b")
+	if refs := s.attach(a); refs != 1 {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+	if refs := s.attach(b); refs != 2 {
+		t.Fatalf("unexpected refs %v", refs)
+	}
+	s.publish(testMessage{seq: 1})
+	if refs, ok := s.detach("a"); !ok || refs != 1 {
+		t.Fatalf("unexpected detach %v, refs %v", ok, refs)
+	}
+	<-a.queue.done
+	s.publish(testMessage{seq: 2})
+	if _, ok := s.detach("a"); ok {
+		t.Fatalf("subscription was detached twice")
+	}
+	if ids := s.closeAll(); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("unexpected closed subscriptions %v", ids)
+	}
+	<-b.queue.done
+	if len(delivered["a"]) != 1 || len(delivered["b"]) != 2 {
+		t.Fatalf("unexpected delivered notifications %v", delivered)
+	}
+}
+
+func TestAdminSubscriptionsHandler(t *testing.T) {
+	os.Setenv("NETWORK", "tcp")
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("cannot create server: %v", err)
+	}
+	s.AdminToken = "secret"
+	s.subscriptionHosts = []string{"localhost"}
+	s.deviceOwners.set("a", "u")
+	handler := s.newAdminHandler()
+
+	tbl := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		token  string
+		status int
+	}{
+		{"WithoutToken", http.MethodPost, adminSubscriptions, `{"uid":"u","di":"a","href":"/a","uri":"http://localhost/"}`, "", http.StatusUnauthorized},
+		{"InvalidJSON", http.MethodPost, adminSubscriptions, `{`, "secret", http.StatusBadRequest},
+		{"MissingURI", http.MethodPost, adminSubscriptions, `{"uid":"u","di":"a","href":"/a"}`, "secret", http.StatusBadRequest},
+		{"MissingUser", http.MethodPost, adminSubscriptions, `{"di":"a","href":"/a","uri":"http://localhost/"}`, "secret", http.StatusBadRequest},
+		{"OtherUser", http.MethodPost, adminSubscriptions, `{"uid":"v","di":"a","href":"/a","uri":"http://localhost/"}`, "secret", http.StatusForbidden},
+		{"HostNotAllowed", http.MethodPost, adminSubscriptions, `{"uid":"u","di":"a","href":"/a","uri":"http://169.254.169.254/"}`, "secret", http.StatusForbidden},
+		{"SchemeNotAllowed", http.MethodPost, adminSubscriptions, `{"uid":"u","di":"a","href":"/a","uri":"file://localhost/etc/passwd"}`, "secret", http.StatusForbidden},
+		{"NotConnected", http.MethodPost, adminSubscriptions, `{"uid":"u","di":"a","href":"/a","uri":"http://localhost:8080/"}`, "secret", http.StatusNotFound},
+		{"UnknownSubscription", http.MethodDelete, adminSubscriptions + "?id=a&uid=u", ``, "secret", http.StatusNotFound},
+		{"MethodNotAllowed", http.MethodGet, adminSubscriptions, ``, "secret", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tbl {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.status {
+				t.Fatalf("unexpected status code %v", w.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-ocf/resources/protobuf/resources"
+)
+
+//notificationThrottling limits notifications of a resource forwarded to resource aggregate
+type notificationThrottling struct {
+	pmin time.Duration // minimal interval between forwarded notifications, notifications within the interval are coalesced
+	pmax time.Duration // maximal interval without a notification, then the resource is retrieved, zero disables it
+}
+
+func (t notificationThrottling) isEmpty() bool {
+	return t.pmin == 0 && t.pmax == 0
+}
+
+func parseNotificationThrottling(value string) (notificationThrottling, error) {
+	v := strings.Split(value, "/")
+	if len(v) != 2 {
+		return notificationThrottling{}, fmt.Errorf("Invalid throttling %v: expected pmin/pmax", value)
+	}
+	pmin, err := time.ParseDuration(v[0])
+	if err != nil {
+		return notificationThrottling{}, fmt.Errorf("Invalid pmin %v: %v", v[0], err)
+	}
+	pmax, err := time.ParseDuration(v[1])
+	if err != nil {
+		return notificationThrottling{}, fmt.Errorf("Invalid pmax %v: %v", v[1], err)
+	}
+	return newNotificationThrottling(pmin, pmax)
+}
+
+func newNotificationThrottling(pmin, pmax time.Duration) (notificationThrottling, error) {
+	if pmin < 0 || pmax < 0 || (pmax > 0 && pmax <= pmin) {
+		return notificationThrottling{}, fmt.Errorf("Invalid throttling pmin %v, pmax %v", pmin, pmax)
+	}
+	return notificationThrottling{pmin: pmin, pmax: pmax}, nil
+}
+
+type throttlingRule struct {
+	resourceRule
+	throttling notificationThrottling
+}
+
+//throttlingPolicy decides throttling of notifications of observed resources.
+//It is configured by comma separated rules in format pmin/pmax, eg.: "0s/0s,rt:oic.r.temperature=10s/1h,href:/light=1s/0s".
+//A bare value sets the default, href rules take precedence over rt rules.
+type throttlingPolicy struct {
+	defaultThrottling notificationThrottling
+	rules             []throttlingRule
+}
+
+//Decode parses throttlingPolicy from env variable
+func (p *throttlingPolicy) Decode(value string) error {
+	var policy throttlingPolicy
+	keys, values := splitPolicyRules(value)
+	for i := range keys {
+		throttling, err := parseNotificationThrottling(values[i])
+		if err != nil {
+			return err
+		}
+		if keys[i] == "" {
+			policy.defaultThrottling = throttling
+			continue
+		}
+		r, err := parseResourceRule(keys[i])
+		if err != nil {
+			return err
+		}
+		policy.rules = append(policy.rules, throttlingRule{resourceRule: r, throttling: throttling})
+	}
+	*p = policy
+	return nil
+}
+
+//throttling returns throttling of the resource, throttling from link policy of the device takes precedence over configuration
+func (p *throttlingPolicy) throttling(res resources.Resource, link notificationThrottling) notificationThrottling {
+	if !link.isEmpty() {
+		return link
+	}
+	for _, href := range []bool{true, false} {
+		for _, r := range p.rules {
+			if r.href == href && r.match(res) {
+				return r.throttling
+			}
+		}
+	}
+	return p.defaultThrottling
+}